@@ -15,6 +15,7 @@ import {
   CardHeader,
   CardTitle,
 } from "@/components/ui/card";
+import { csrfHeaders } from "@/lib/csrf";
 
 function LoginPageHolder() {
   const [isLoading, setIsLoading] = useState(false);
@@ -60,7 +61,7 @@ function LoginPageHolder() {
         `${process.env.NEXT_PUBLIC_AUTH_URL}/api/auth/login`,
         {
           method: "POST",
-          headers: { "Content-Type": "application/json" },
+          headers: csrfHeaders({ "Content-Type": "application/json" }),
           body: JSON.stringify({ email, password, token }),
           credentials: "include",
         }
@@ -74,7 +75,7 @@ function LoginPageHolder() {
         // From where ever you redirect use router.push(`/login?callbackUrl=${encodeURIComponent(router.asPath)}`);
         router.replace(callbackUrl);
       } else {
-        toast.error(data.error || "Login failed");
+        toast.error(data.message || "Login failed");
       }
     } catch {
       toast.error("Something went wrong. Try again later.");
@@ -7,6 +7,7 @@ import { Label } from "@/components/ui/label";
 import { Button } from "@/components/ui/button";
 import { toast } from "sonner";
 import { Card, CardContent, CardHeader, CardTitle } from "@/components/ui/card";
+import { csrfHeaders } from "@/lib/csrf";
 
 const CopyIcon = () => (
   <svg
@@ -115,6 +116,7 @@ export default function NoticeboardForm() {
           `${process.env.NEXT_PUBLIC_ASSET_URL}/assets`,
           {
             method: "POST",
+            headers: csrfHeaders(),
             body: imageFormData,
             credentials: "include",
           }
@@ -195,9 +197,9 @@ export default function NoticeboardForm() {
         `${process.env.NEXT_PUBLIC_MAPS_URL}/api/maps/notice`,
         {
           method: "POST",
-          headers: {
+          headers: csrfHeaders({
             "Content-Type": "application/json",
-          },
+          }),
           body: JSON.stringify(formData),
           credentials: "include",
         }
@@ -206,7 +208,7 @@ export default function NoticeboardForm() {
       if (!response.ok) {
         // error message from backend response
         const errorData = await response.json();
-        throw new Error(errorData.error || "Something went wrong");
+        throw new Error(errorData.message || "Something went wrong");
       }
 
       // successful
@@ -24,6 +24,7 @@ import StarRating from "@/app/components/user/Rate";
 import { toast } from "sonner";
 import { ImagePlus, Loader2 } from "lucide-react";
 import { useMediaQuery } from "@/app/hooks/use-media-query";
+import { csrfHeaders } from "@/lib/csrf";
 
 interface ReviewDrawerProps {
   locationId: string;
@@ -70,6 +71,7 @@ export function ReviewDrawer({
             "http://localhost:8082";
           const imgRes = await fetch(`${assetUrl}/assets`, {
             method: "POST",
+            headers: csrfHeaders(),
             credentials: "include",
             body: imagePayload,
           });
@@ -110,9 +112,9 @@ export function ReviewDrawer({
         `${process.env.NEXT_PUBLIC_MAPS_URL}/api/maps/review`,
         {
           method: "POST",
-          headers: {
+          headers: csrfHeaders({
             "Content-Type": "application/json",
-          },
+          }),
           body: JSON.stringify(reviewPayload),
           credentials: "include",
         }
@@ -128,7 +130,7 @@ export function ReviewDrawer({
         setDescription("");
         setImage(null);
       } else {
-        toast.error(data.error || "Failed to submit review.");
+        toast.error(data.message || "Failed to submit review.");
       }
     } catch (err) {
       console.error(err);
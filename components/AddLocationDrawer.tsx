@@ -39,6 +39,7 @@ import {
   Loader2,
 } from "lucide-react";
 import { useMediaQuery } from "@/app/hooks/use-media-query";
+import { csrfHeaders } from "@/lib/csrf";
 
 export default function AddLocationDrawer({
   open,
@@ -128,6 +129,7 @@ export default function AddLocationDrawer({
           const assetUrl = process.env.NEXT_PUBLIC_ASSET;
           const imgRes = await fetch(`${assetUrl}/assets`, {
             method: "POST",
+            headers: csrfHeaders(),
             credentials: "include",
             body: imagePayload,
           });
@@ -164,7 +166,7 @@ export default function AddLocationDrawer({
       const res = await fetch(apiUrl, {
         method: "POST",
         credentials: "include",
-        headers: { "Content-Type": "application/json" },
+        headers: csrfHeaders({ "Content-Type": "application/json" }),
         body: JSON.stringify(payload),
       });
 
@@ -186,7 +188,7 @@ export default function AddLocationDrawer({
         onOpenChange(false);
         window.dispatchEvent(new Event("refresh-markers"));
       } else {
-        toast.error(data.error || "Failed to add location.");
+        toast.error(data.message || "Failed to add location.");
       }
     } catch (err) {
       console.error(err);
@@ -26,6 +26,7 @@ import {
   AlertDeleteProfileInfo,
   AlertVisibilityProfileInfo,
 } from "./ProfileAction";
+import { csrfHeaders } from "@/lib/csrf";
 
 export function EditableProfileCard({
   profile,
@@ -67,7 +68,7 @@ export function EditableProfileCard({
         `${process.env.NEXT_PUBLIC_AUTH_URL}/api/profile`,
         {
           method: "POST",
-          headers: { "Content-Type": "application/json" },
+          headers: csrfHeaders({ "Content-Type": "application/json" }),
           credentials: "include",
           body: JSON.stringify(formData),
         }
@@ -78,7 +79,7 @@ export function EditableProfileCard({
         setIsEditing(false);
         onUpdate();
       } else {
-        toast.error(data.error || "Failed to update.");
+        toast.error(data.message || "Failed to update.");
       }
     } catch {
       toast.error("An unexpected error occurred.");
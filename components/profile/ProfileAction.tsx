@@ -14,6 +14,7 @@ import { useState } from "react";
 import { cn } from "@/lib/utils";
 import { useGContext } from "../ContextProvider";
 import { toast } from "sonner";
+import { csrfHeaders } from "@/lib/csrf";
 
 export function AlertDeleteProfileInfo() {
   const { setGlobalLoading } = useGContext();
@@ -25,9 +26,9 @@ export function AlertDeleteProfileInfo() {
         `${process.env.NEXT_PUBLIC_SEARCH_SERVER}/api/search/`,
         {
           method: "DELETE",
-          headers: {
+          headers: csrfHeaders({
             "Content-Type": "application/json",
-          },
+          }),
           credentials: "include",
         }
       );
@@ -137,9 +138,9 @@ export function AlertVisibilityProfileInfo({
         `${process.env.NEXT_PUBLIC_SEARCH_SERVER}/api/search/toggleVisibility`,
         {
           method: "POST",
-          headers: {
+          headers: csrfHeaders({
             "Content-Type": "application/json",
-          },
+          }),
           body: JSON.stringify({ visibility: nextState }),
           credentials: "include", // Passes auth cookies
         }
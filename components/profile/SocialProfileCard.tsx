@@ -9,6 +9,7 @@ import { useRouter } from "next/navigation";
 import { useGContext } from "@/components/ContextProvider";
 import { ModeToggle } from "@/components/ui/mode-toggle";
 import { useState, useEffect } from "react";
+import { csrfHeaders } from "@/lib/csrf";
 
 // TODO: CC blocks showing the profile images on other urls hence need to add it to the bg like earlier, can't use next js Image, Avatar.
 // TODO: Add tool tips
@@ -67,6 +68,7 @@ export function SocialProfileCard({
 
       const res = await fetch(`${BACKEND_URL}/api/profile/pfp`, {
         method: "POST",
+        headers: csrfHeaders(),
         credentials: "include",
         body: formData,
       });
@@ -78,7 +80,7 @@ export function SocialProfileCard({
         // setPreview(`${BACKEND_URL}/${data.imagePath}`);
         onProfileUpdate?.();
       } else {
-        toast.error(data.error || "Upload failed");
+        toast.error(data.message || "Upload failed");
       }
     } catch {
       toast.error("Error uploading image");
@@ -98,7 +100,8 @@ export function SocialProfileCard({
     try {
       setGlobalLoading(true);
       const res = await fetch(`${BACKEND_URL}/api/auth/logout`, {
-        method: "GET",
+        method: "POST",
+        headers: csrfHeaders(),
         credentials: "include",
       });
 
@@ -15,6 +15,7 @@ import { Label } from "@/components/ui/label";
 import { toast } from "sonner";
 import Link from "next/link";
 import ReCAPTCHA from "react-google-recaptcha";
+import { csrfHeaders } from "@/lib/csrf";
 
 interface Step1RegisterProps {
   onSuccess: (data: { userID: string }) => void;
@@ -58,8 +59,9 @@ export function Step1Register({ onSuccess }: Step1RegisterProps) {
         `${process.env.NEXT_PUBLIC_AUTH_URL}/api/auth/signup`,
         {
           method: "POST",
-          headers: { "Content-Type": "application/json" },
+          headers: csrfHeaders({ "Content-Type": "application/json" }),
           body: JSON.stringify({ email, password, token }),
+          credentials: "include",
         }
       );
 
@@ -69,7 +71,7 @@ export function Step1Register({ onSuccess }: Step1RegisterProps) {
         toast.success(data.message);
         onSuccess({ userID: data.userID });
       } else {
-        toast.error(data.error || "Signup failed");
+        toast.error(data.message || "Signup failed");
       }
     } catch {
       toast.error("An unexpected error occurred.");
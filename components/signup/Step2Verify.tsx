@@ -56,7 +56,7 @@ export function Step2Verify({ userID, onSuccess }: Step2VerifyProps) {
         toast.success(data.message);
         onSuccess();
       } else {
-        toast.error(data.error);
+        toast.error(data.message);
       }
     } catch {
       toast.error("An unexpected error occurred during verification.");
@@ -28,6 +28,7 @@ import {
 } from "@/components/ui/select";
 import { toast } from "sonner";
 import { courses, halls, departmentNameMap } from "@/components/Constant";
+import { csrfHeaders } from "@/lib/csrf";
 
 export function Step3Profile() {
   const router = useRouter();
@@ -129,6 +130,7 @@ export function Step3Profile() {
         `${process.env.NEXT_PUBLIC_AUTH_URL}/api/profile/pfp`,
         {
           method: "POST",
+          headers: csrfHeaders(),
           body: formData,
           credentials: "include",
         }
@@ -138,7 +140,7 @@ export function Step3Profile() {
         toast.success("Profile picture uploaded!");
         return true;
       } else {
-        toast.error(data.error || "Failed to upload image.");
+        toast.error(data.message || "Failed to upload image.");
         return false;
       }
     } catch {
@@ -186,7 +188,7 @@ export function Step3Profile() {
         `${process.env.NEXT_PUBLIC_AUTH_URL}/api/profile`,
         {
           method: "POST",
-          headers: { "Content-Type": "application/json" },
+          headers: csrfHeaders({ "Content-Type": "application/json" }),
           credentials: "include",
           body: JSON.stringify(profileData),
         }
@@ -197,7 +199,7 @@ export function Step3Profile() {
         toast.success(data.message || "Profile updated successfully!");
         router.push("/profile");
       } else {
-        toast.error(data.error || "Failed to update profile.");
+        toast.error(data.message || "Failed to update profile.");
       }
     } catch {
       toast.error("An unexpected error occurred.");
@@ -0,0 +1,9 @@
+// The servers set the csrf_token cookie and expect it back in the X-CSRF-Token header on POST/PUT/DELETE.
+export function csrfHeaders(
+  headers: Record<string, string> = {}
+): Record<string, string> {
+  if (typeof document === "undefined") return headers;
+  const match = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]*)/);
+  if (!match) return headers;
+  return { ...headers, "X-CSRF-Token": decodeURIComponent(match[1]) };
+}
@@ -35,17 +35,16 @@ export async function fetch_student_data(): Promise<Student[] | null> {
 
 export async function fetch_changelog(lastTime: Timestamp) {
   try {
-    const resp = await fetch(`${SEARCH_POINT}/api/search/changeLog`, {
-      method: "POST",
+    const query = new URLSearchParams({
+      lastUpdateTime: new Date(lastTime).toISOString(),
+    });
+    const resp = await fetch(`${SEARCH_POINT}/api/search/changeLog?${query}`, {
       credentials: "include",
-      body: JSON.stringify({
-        lastUpdateTime: new Date(lastTime).toISOString(),
-      }),
     });
     if (!resp.ok) {
       postMessage({
         status: "error",
-        message: (await resp.json())?.error || "An error occurred during fetch changes"
+        message: (await resp.json())?.message || "An error occurred during fetch changes"
       })
       throw new Error(`Status code: ${resp.status} ${resp.statusText}`);
     }
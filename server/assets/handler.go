@@ -1,7 +1,7 @@
 package assets
 
 import (
-	"compass/connections"
+	"compass/middleware"
 	"compass/model"
 	"compass/workers"
 	"encoding/json"
@@ -13,12 +13,12 @@ import (
 func uploadAsset(c *gin.Context) {
 	var req ImageUploadRequest
 	if err := c.ShouldBind(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Image is required"})
+		middleware.Fail(c, middleware.BadRequest("Image is required"))
 		return
 	}
 	userID, exist := c.Get("userID")
 	if !exist {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
 		return
 	}
 	image := model.Image{
@@ -30,13 +30,13 @@ func uploadAsset(c *gin.Context) {
 	file := req.File
 	// Compress and convert the image to webp
 	if img, err := cncImage(file); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in compressing the image"})
+		middleware.Fail(c, middleware.Internal(err, "Error in compressing the image"))
 		// TODO: // ./ vs no
 	} else if path, err := saveImage(img, "./assets/tmp", image.ImageID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in saving image"})
-	} else if err := connections.DB.Model(&model.Image{}).Create(&image).Error; err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Error in saving image"))
+	} else if err := middleware.DB(c).Model(&model.Image{}).Create(&image).Error; err != nil {
 		// Add entry in the table and save the image in the server
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error adding image to server"})
+		middleware.Fail(c, middleware.Internal(err, "Error adding image to server"))
 		// Delete the image
 		deleteImage(path)
 		return
@@ -47,8 +47,8 @@ func uploadAsset(c *gin.Context) {
 		}
 
 		payload, _ := json.Marshal(moderationJob)
-		if err := workers.PublishJob(payload, "moderation"); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue moderation job"})
+		if err := workers.PublishJob(c.Request.Context(), payload, "moderation"); err != nil {
+			middleware.Fail(c, middleware.Internal(err, "Failed to queue moderation job"))
 			deleteImage(path)
 			return
 		}
@@ -22,7 +22,7 @@ func Router(r *gin.Engine) {
 
 	// Require login to upload image
 	r.Use(middleware.UserAuthenticator, middleware.EmailVerified)
-	r.POST("/assets", uploadAsset)
+	r.POST("/assets", middleware.RateLimit("contribution"), uploadAsset)
 
 	// Admin can see tmp files too
 	r.Static("/tmp", "./assets/tmp")
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"compass/middleware"
+	"compass/model"
+	"compass/workers"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// changeEmailHandler mails a code to the new address, the email changes only once it is confirmed
+func changeEmailHandler(c *gin.Context) {
+	userID, exist := c.Get("userID")
+	if !exist {
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
+		return
+	}
+	var input ChangeEmailRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		middleware.Fail(c, middleware.InvalidInput(err))
+		return
+	}
+	newEmail := strings.ToLower(strings.TrimSpace(input.NewEmail))
+	// Same rule as the signup
+	if !strings.HasSuffix(newEmail, "@iitk.ac.in") {
+		middleware.Fail(c, middleware.BadRequest("Please use a valid IIT Kanpur email address"))
+		return
+	}
+
+	var user model.User
+	if err := middleware.DB(c).Model(&model.User{}).Select("user_id", "email", "password").
+		Where("user_id = ?", userID.(uuid.UUID)).First(&user).Error; err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to fetch user"))
+		return
+	}
+	if strings.EqualFold(user.Email, newEmail) {
+		middleware.Fail(c, middleware.BadRequest("This is already your email"))
+		return
+	}
+
+	// The password is asked again, a stolen session must not be enough to take over the account
+	if !confirmPassword(c, user, input.Password) {
+		return
+	}
+
+	// Taken addresses, including soft deleted accounts as the unique index still holds them
+	var taken int64
+	if err := middleware.DB(c).Unscoped().Model(&model.User{}).Where("LOWER(email) = ?", newEmail).Count(&taken).Error; err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Database error"))
+		return
+	}
+	if taken > 0 {
+		middleware.Fail(c, middleware.Conflict("This email is already in use"))
+		return
+	}
+
+	// Same limits as the verification mails
+	wait, err := takeQuota(
+		c.Request.Context(),
+		"email_change:"+user.UserID.String(),
+		time.Duration(viper.GetInt("verification.resendCooldown"))*time.Second,
+		viper.GetInt("verification.resendDailyLimit"),
+		24*time.Hour,
+	)
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Request Failed, Please try again later"))
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		middleware.Fail(c, middleware.TooManyRequests("Please wait before requesting another code"))
+		return
+	}
+
+	expiry := viper.GetInt("expiry.emailChange")
+	code, err := issueOTP(c.Request.Context(), user.UserID, model.EmailChangeOTP, newEmail, time.Duration(expiry)*time.Minute)
+	if err != nil {
+		logrus.WithContext(c.Request.Context()).Error("Failed to issue email change code: ", err)
+		middleware.Fail(c, middleware.Internal(err, "Unable to process the request, please try again later"))
+		return
+	}
+	job := workers.MailJob{
+		Type: "email_change",
+		To:   newEmail,
+		Data: map[string]interface{}{
+			"token":  fmt.Sprintf("%s-%s", code[:3], code[3:]),
+			"expiry": expiry,
+		},
+	}
+	payload, _ := json.Marshal(job)
+	if err := workers.PublishJob(c.Request.Context(), payload, model.MailQueue); err != nil {
+		logrus.WithContext(c.Request.Context()).Error("Failed to enqueue email change mail:", err)
+		middleware.Fail(c, middleware.Internal(err, "Unable to send the code, please try again later"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "A code has been sent to the new email, enter it to confirm the change"})
+}
+
+// confirmEmailChangeHandler switches the account to the new address with the code mailed to it
+func confirmEmailChangeHandler(c *gin.Context) {
+	userID, exist := c.Get("userID")
+	if !exist {
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
+		return
+	}
+	var input ConfirmEmailChangeRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		middleware.Fail(c, middleware.InvalidInput(err))
+		return
+	}
+
+	newEmail, err := consumeOTP(c.Request.Context(), userID.(uuid.UUID), model.EmailChangeOTP, normalizeOTP(input.Token))
+	if err != nil {
+		switch {
+		case errors.Is(err, errOTPTooManyAttempts):
+			middleware.Fail(c, middleware.TooManyRequests("Too many wrong attempts, please request a new code"))
+		case errors.Is(err, errOTPInvalid):
+			middleware.Fail(c, middleware.BadRequest("Invalid or expired code"))
+		default:
+			middleware.Fail(c, middleware.Internal(err, "Database error"))
+		}
+		return
+	}
+
+	var oldEmail string
+	err = middleware.DB(c).Transaction(func(tx *gorm.DB) error {
+		var user model.User
+		if err := tx.Model(&model.User{}).Select("user_id", "email").
+			Where("user_id = ?", userID).First(&user).Error; err != nil {
+			return err
+		}
+		oldEmail = user.Email
+		if err := tx.Model(&model.User{}).Where("user_id = ?", user.UserID).Update("email", newEmail).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.Profile{}).Where("user_id = ?", user.UserID).Update("email", newEmail).Error; err != nil {
+			return err
+		}
+		// Search clients resync the profile
+		if err := tx.Where("user_id = ?", user.UserID).Delete(&model.ChangeLog{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.ChangeLog{UserID: user.UserID, Action: model.Update}).Error
+	})
+	if err != nil {
+		// Someone took the address after the code was sent
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			middleware.Fail(c, middleware.Conflict("This email is already in use"))
+			return
+		}
+		logrus.WithContext(c.Request.Context()).Errorf("Failed to change email of user %s: %v", userID, err)
+		middleware.Fail(c, middleware.Internal(err, "Unable to change the email"))
+		return
+	}
+
+	// The old address hears about it, in case it was not the owner
+	job := workers.MailJob{
+		Type: "email_changed",
+		To:   oldEmail,
+		Data: map[string]interface{}{"newEmail": newEmail},
+	}
+	payload, _ := json.Marshal(job)
+	if err := workers.PublishJob(c.Request.Context(), payload, model.MailQueue); err != nil {
+		logrus.WithContext(c.Request.Context()).Error("Failed to enqueue email changed notice:", err)
+	}
+	// Every other device logs in again, with the new email
+	currentID, _ := middleware.CurrentSessionID(c)
+	if err := middleware.RevokeAllSessions(c.Request.Context(), userID.(uuid.UUID), currentID); err != nil {
+		logrus.WithContext(c.Request.Context()).Errorf("Failed to revoke sessions of user %s after email change: %v", userID, err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email changed successfully", "email": newEmail})
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"compass/middleware"
+	"compass/model"
+	"compass/workers"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// requestExportHandler queues the "download my data" archive, the link is mailed once it is ready
+func requestExportHandler(c *gin.Context) {
+	userID, exist := c.Get("userID")
+	if !exist {
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
+		return
+	}
+
+	// Building an archive is heavy, one per cooldown is enough
+	cooldown := time.Duration(viper.GetInt("export.cooldown")) * time.Hour
+	var last model.DataExport
+	err := middleware.DB(c).Where("user_id = ? AND status <> ?", userID, model.ExportFailed).
+		Order("created_at DESC").First(&last).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		middleware.Fail(c, middleware.Internal(err, "Database error"))
+		return
+	}
+	if err == nil {
+		if wait := time.Until(last.CreatedAt.Add(cooldown)); wait > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			middleware.Fail(c, middleware.TooManyRequests("An export was requested recently, check your mail").WithDetails(gin.H{"export": last}))
+			return
+		}
+	}
+
+	export := model.DataExport{
+		ExportID: uuid.New(),
+		UserID:   userID.(uuid.UUID),
+		Status:   model.ExportPending,
+	}
+	if err := middleware.DB(c).Create(&export).Error; err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to request the export"))
+		return
+	}
+	payload, _ := json.Marshal(workers.ExportJob{ExportID: export.ExportID})
+	if err := workers.PublishJob(c.Request.Context(), payload, model.ExportQueue); err != nil {
+		logrus.WithContext(c.Request.Context()).Error("Failed to enqueue export: ", err)
+		middleware.DB(c).Delete(&export)
+		middleware.Fail(c, middleware.Internal(err, "Failed to request the export, please try again later"))
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Your data is being prepared, you will get a download link by mail", "export": export})
+}
+
+// downloadExportHandler serves the archive, only to its owner
+func downloadExportHandler(c *gin.Context) {
+	userID, exist := c.Get("userID")
+	if !exist {
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
+		return
+	}
+	exportID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.Fail(c, middleware.BadRequest("Invalid export ID format"))
+		return
+	}
+	var export model.DataExport
+	// Someone else's export is the same as a missing one
+	if err := middleware.DB(c).Where("export_id = ? AND user_id = ?", exportID, userID).First(&export).Error; err != nil {
+		middleware.Fail(c, middleware.NotFound("Export not found"))
+		return
+	}
+	switch {
+	case export.Status == model.ExportPending:
+		c.JSON(http.StatusAccepted, gin.H{"message": "Your data is still being prepared", "export": export})
+	case export.Status == model.ExportFailed:
+		middleware.Fail(c, middleware.Gone("The export failed, please request a new one"))
+	case export.ExpiresAt == nil || time.Now().After(*export.ExpiresAt):
+		middleware.Fail(c, middleware.Gone("The download link has expired, please request a new export"))
+	default:
+		c.FileAttachment(export.FilePath, fmt.Sprintf("compass-data-%s.zip", export.CreatedAt.Format("2006-01-02")))
+	}
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"compass/middleware"
+	"compass/model"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// startImpersonationHandler lets an admin view the app as a user, every start is logged with the reason
+func startImpersonationHandler(c *gin.Context) {
+	adminID, exist := c.Get("userID")
+	if !exist {
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
+		return
+	}
+	if _, ok := c.Get("impersonator"); ok {
+		middleware.Fail(c, middleware.BadRequest("Stop the current impersonation first"))
+		return
+	}
+	// The scope is meant for admins in the browser, a key must not turn into session cookies
+	if middleware.IsAPIKeyRequest(c) {
+		middleware.Fail(c, middleware.Forbidden("API keys can't start an impersonation"))
+		return
+	}
+	var input ImpersonateRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		middleware.Fail(c, middleware.InvalidInput(err))
+		return
+	}
+	if input.UserID == adminID.(uuid.UUID) {
+		middleware.Fail(c, middleware.BadRequest("Cannot impersonate yourself"))
+		return
+	}
+
+	var target model.User
+	if err := middleware.DB(c).Model(&model.User{}).Select("user_id", "email", "role").
+		Where("user_id = ?", input.UserID).First(&target).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			middleware.Fail(c, middleware.NotFound("User not found"))
+		} else {
+			middleware.Fail(c, middleware.Internal(err, "Database error"))
+		}
+		return
+	}
+	// Viewing as a peer or a higher role would hand out their permissions
+	if target.Role >= model.Role(c.GetInt("userRole")) {
+		middleware.Fail(c, middleware.Forbidden("Cannot impersonate a user with the same or a higher role"))
+		return
+	}
+
+	minutes := viper.GetInt("impersonation.expiry")
+	expiry := time.Duration(minutes) * time.Minute
+	mode := "read only"
+	if input.Elevated {
+		mode = "elevated"
+	}
+	impersonation := model.Impersonation{
+		ID:        uuid.New(),
+		AdminID:   adminID.(uuid.UUID),
+		UserID:    target.UserID,
+		Elevated:  input.Elevated,
+		ExpiresAt: time.Now().Add(expiry),
+	}
+	// Logged before the token is issued, an impersonation must never go unrecorded. The token can't outlive
+	// the expiry in the entry, the cleanup worker logs the end of an impersonation which was never stopped.
+	entry := model.NewLog(
+		"Impersonation started",
+		fmt.Sprintf("Admin %s started %s impersonation %s of %s (%s) until %s, reason: %s",
+			adminID, mode, impersonation.ID, target.Email, target.UserID, impersonation.ExpiresAt.Format(time.RFC3339), input.Reason),
+		model.AdminActor,
+	)
+	if err := middleware.DB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&impersonation).Error; err != nil {
+			return err
+		}
+		return tx.Create(&entry).Error
+	}); err != nil {
+		logrus.WithContext(c.Request.Context()).Error("Failed to log impersonation start: ", err)
+		middleware.Fail(c, middleware.Internal(err, "Database error"))
+		return
+	}
+	if err := middleware.StartImpersonation(c, impersonation, expiry); err != nil {
+		logrus.WithContext(c.Request.Context()).Error("Failed to issue impersonation token: ", err)
+		middleware.Fail(c, middleware.Internal(err, "Failed to generate token"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Impersonating " + target.Email,
+		"elevated":  input.Elevated,
+		"expiresAt": impersonation.ExpiresAt,
+	})
+}
+
+// stopImpersonationHandler drops the impersonation token, the admin continues with their own session
+func stopImpersonationHandler(c *gin.Context) {
+	claims, ok := middleware.ImpersonationClaims(c)
+	if !ok {
+		middleware.Fail(c, middleware.BadRequest("Not impersonating anyone"))
+		return
+	}
+	middleware.EndImpersonation(c)
+	entry := model.NewLog(
+		"Impersonation stopped",
+		fmt.Sprintf("Admin %s stopped the impersonation %s of %s", claims.Impersonator, claims.ID, claims.UserID),
+		model.AdminActor,
+	)
+	if err := middleware.DB(c).Transaction(func(tx *gorm.DB) error {
+		// Ended here, the cleanup worker must not log it as expired as well
+		if impersonationID, err := uuid.Parse(claims.ID); err == nil {
+			if err := tx.Model(&model.Impersonation{}).Where("id = ? AND ended_at IS NULL", impersonationID).
+				Update("ended_at", time.Now()).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&entry).Error
+	}); err != nil {
+		logrus.WithContext(c.Request.Context()).Error("Failed to log impersonation stop: ", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Impersonation stopped"})
+}
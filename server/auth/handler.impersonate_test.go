@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"bytes"
+	"compass/connections"
+	"compass/middleware"
+	"compass/model"
+	"compass/testutil"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestImpersonationStartAndStopAudited(t *testing.T) {
+	testutil.UseDB(t)
+	admin := createVerifiedUser(t, "admin@iitk.ac.in")
+	target := createVerifiedUser(t, "student@iitk.ac.in")
+	r := gin.New()
+	r.Use(middleware.Errors())
+	r.POST("/impersonate", func(c *gin.Context) {
+		c.Set("userID", admin.UserID)
+		c.Set("userRole", int(model.SuperAdminRole))
+		c.Next()
+	}, startImpersonationHandler)
+	r.DELETE("/impersonate", stopImpersonationHandler)
+
+	body, _ := json.Marshal(ImpersonateRequest{UserID: target.UserID, Reason: "bug report"})
+	req := httptest.NewRequest(http.MethodPost, "/impersonate", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("start status = %d, body %s", w.Code, w.Body)
+	}
+	var impersonation model.Impersonation
+	if err := connections.DB.First(&impersonation).Error; err != nil {
+		t.Fatalf("impersonation not recorded: %v", err)
+	}
+	if impersonation.AdminID != admin.UserID || impersonation.UserID != target.UserID || impersonation.EndedAt != nil {
+		t.Errorf("recorded %+v, want an open impersonation of the target by the admin", impersonation)
+	}
+
+	stop := httptest.NewRequest(http.MethodDelete, "/impersonate", nil)
+	for _, cookie := range w.Result().Cookies() {
+		stop.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, stop)
+	if w.Code != http.StatusOK {
+		t.Fatalf("stop status = %d, body %s", w.Code, w.Body)
+	}
+	if err := connections.DB.First(&impersonation, "id = ?", impersonation.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if impersonation.EndedAt == nil {
+		t.Error("stopped impersonation has no end")
+	}
+
+	var logs []model.Logs
+	if err := connections.DB.Order("id").Find(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 || logs[0].Title != "Impersonation started" || logs[1].Title != "Impersonation stopped" {
+		t.Fatalf("got logs %+v, want the start and the stop", logs)
+	}
+	for _, entry := range logs {
+		if !strings.Contains(entry.Description, impersonation.ID.String()) {
+			t.Errorf("log %q does not name the impersonation %s", entry.Description, impersonation.ID)
+		}
+	}
+}
@@ -1,102 +1,120 @@
 package auth
 
 import (
-	"bytes"
-	"compass/connections"
 	"compass/middleware"
 	"compass/model"
-	"encoding/json"
-	"io"
+	"compass/workers"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/spf13/viper"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
-// Helper Function: Verify Recaptcha calls Google API to validate token
-func verifyRecaptcha(token string) bool {
-	secret := viper.GetString("recaptcha.key")
-	url := "https://www.google.com/recaptcha/api/siteverify"
-
-	data := []byte("secret=" + secret + "&response=" + token)
-	resp, err := http.Post(url, "application/x-www-form-urlencoded", bytes.NewBuffer(data))
-	if err != nil {
-		return false
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var recaptchaResp RecaptchaResponse
-	if err := json.Unmarshal(body, &recaptchaResp); err != nil {
-		return false
-	}
-
-	// FOR DEV: You may keep it always true
-	// Only accept if successful and score >= 0.5
-	if recaptchaResp.Success && recaptchaResp.Score >= 0.5 {
-		return true
-	}
-	return false
-}
+// bcrypt hash of a random password, used to keep the login timing same for unknown emails
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte(uuid.NewString()), bcrypt.DefaultCost)
 
 func loginHandler(c *gin.Context) {
-	var req LoginSignupRequest
+	var req LoginRequest
 	var dbUser model.User
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		middleware.Fail(c, middleware.InvalidInput(err))
 		return
 	}
 
-	// FOR DEV: BYPASS RE-CAPTCHA
-	// ----------------------------------------------------------------------------- //
-	if (viper.GetString("env") == "prod"){
-		if !verifyRecaptcha(req.Token) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Failed captcha verification"})
+	// Brute force protection, the same response for both keys and for unknown emails
+	emailKey, ipKey := emailThrottleKey(req.Email), ipThrottleKey(c.ClientIP())
+	for _, key := range []string{emailKey, ipKey} {
+		wait, err := throttleWait(c.Request.Context(), key)
+		if err != nil {
+			middleware.Fail(c, middleware.Internal(err, "Database error"))
+			return
+		}
+		if wait > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			middleware.Fail(c, middleware.TooManyRequests("Too many failed attempts, please try again later"))
 			return
 		}
 	}
-	// ----------------------------------------------------------------------------- //
 
 	//  Fetch user from DB
-	result := connections.DB.Model(&model.User{}).Select("email", "user_id", "password", "role", "is_verified").
+	result := middleware.DB(c).Model(&model.User{}).Select("email", "user_id", "password", "role", "is_verified").
 		Where("email = ?", req.Email).First(&dbUser)
-	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		}
+	if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
+		middleware.Fail(c, middleware.Internal(result.Error, "Database error"))
 		return
 	}
 
 	//  Checking password
-	if err := bcrypt.CompareHashAndPassword([]byte(dbUser.Password), []byte(req.Password)); err != nil {
+	// For unknown emails compare against a dummy hash, so the response time does not tell if the account exists
+	passwordHash := dummyPasswordHash
+	if result.Error == nil {
+		passwordHash = []byte(dbUser.Password)
+	}
+	if err := bcrypt.CompareHashAndPassword(passwordHash, []byte(req.Password)); err != nil || result.Error != nil {
+		for key, kind := range map[string]string{emailKey: "email", ipKey: "ip"} {
+			locked, err := recordLoginFailure(c.Request.Context(), key, loginLimits(kind))
+			if err != nil {
+				logrus.WithContext(c.Request.Context()).Errorf("Failed to record login failure for %s: %v", key, err)
+			} else if locked {
+				logLockout(c.Request.Context(), key, c.ClientIP())
+			}
+		}
 		middleware.ClearAuthCookie(c)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		middleware.Fail(c, middleware.Unauthorized("Invalid credentials"))
 		return
 	}
+	// Only the email is forgiven, the ip key is left to its window: else one working account
+	// would clear the count of a stuffing run against the others from the same ip
+	resetThrottle(c.Request.Context(), emailKey)
+
 	// check if verified
 	if !dbUser.IsVerified {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Email not verified"})
+		middleware.Fail(c, middleware.Unauthorized("Email not verified"))
 		return
 	}
 
-	// Creating JWT token
-	accessToken, err := middleware.GenerateAccessToken(dbUser.UserID);
-	refreshToken, err := middleware.GenerateRefreshToken(dbUser.UserID);
+	challenged, err := completeLogin(c, dbUser.UserID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		middleware.Fail(c, middleware.Internal(err, "Failed to generate token"))
 		return
 	}
+	if challenged {
+		c.JSON(http.StatusOK, gin.H{"message": "Enter the code from your authenticator app", "twoFactorRequired": true})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Login successful"})
+}
+
+// issueSession starts a new session for the user and sets both the auth cookies,
+// every login method (password, otp verification, sso) ends here. twoFactor tells if the login passed the second factor.
+func issueSession(c *gin.Context, userID uuid.UUID, twoFactor bool) error {
+	// Creating JWT token
+	accessToken, err := middleware.GenerateAccessToken(c.Request.Context(), userID, twoFactor)
+	if err != nil {
+		return err
+	}
+	refreshToken, err := middleware.GenerateRefreshToken(c, userID, twoFactor)
+	if err != nil {
+		return err
+	}
+
+	// Logging in is how a pending deletion gets cancelled
+	if err := workers.CancelAccountDeletion(c.Request.Context(), userID); err != nil {
+		return err
+	}
 
 	// Clear the previous cookie
 	middleware.ClearAuthCookie(c)
 	// Set cookie
 	middleware.SetAuthCookie(c, accessToken)
 	middleware.SetRefreshCookie(c, refreshToken)
-
-	c.JSON(http.StatusOK, gin.H{"message": "Login successful"})
+	middleware.SetCSRFCookie(c)
+	return nil
 }
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"bytes"
+	"compass/connections"
+	"compass/middleware"
+	"compass/model"
+	"compass/testutil"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func postLogin(r *gin.Engine, email, password string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(LoginRequest{Email: email, Password: password})
+	req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// A login forgives the failures of its email, not the ones of its ip
+func TestLoginResetsOnlyEmailThrottle(t *testing.T) {
+	testutil.UseDB(t)
+	user := createVerifiedUser(t, "student@iitk.ac.in")
+	r := gin.New()
+	r.Use(middleware.Errors())
+	r.POST("/login", loginHandler)
+
+	if w := postLogin(r, user.Email, "wrong password"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password status = %d, body %s", w.Code, w.Body)
+	}
+	if w := postLogin(r, user.Email, testPassword); w.Code != http.StatusOK {
+		t.Fatalf("login status = %d, body %s", w.Code, w.Body)
+	}
+
+	var throttles []model.Throttle
+	if err := connections.DB.Find(&throttles).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(throttles) != 1 || throttles[0].Key != ipThrottleKey("192.0.2.1") || throttles[0].Count != 1 {
+		t.Errorf("throttles after the login = %+v, want only the ip key with one failure", throttles)
+	}
+}
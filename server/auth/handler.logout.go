@@ -5,9 +5,14 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
 func logoutHandler(c *gin.Context) {
+	// Revoke server side as well, clearing the cookie alone leaves a copied refresh token usable
+	if err := middleware.RevokeRefreshCookie(c); err != nil {
+		logrus.WithContext(c.Request.Context()).Error("Failed to revoke refresh token on logout: ", err)
+	}
 	middleware.ClearAuthCookie(c)
 	c.JSON(http.StatusOK, gin.H{"message": "Logged Out Successfully"})
 }
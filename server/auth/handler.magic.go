@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"compass/middleware"
+	"compass/model"
+	"compass/workers"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// Cookie binding a magic link to the browser it was requested from, a link forwarded or leaked from the mailbox is useless elsewhere
+const magicNonceCookie = "magic_nonce"
+
+// Same response whether the account exists or has opted in, so this route can't be used to find registered emails
+const magicLinkMessage = "If passwordless login is enabled for this email, a login link has been sent to it."
+
+// magicLinkClaims is the signed token in the link, Code is the single use OneTimeCode
+type magicLinkClaims struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Code      string    `json:"code"`
+	NonceHash string    `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+func hashNonce(nonce string) string {
+	sum := sha256.Sum256([]byte(nonce))
+	return hex.EncodeToString(sum[:])
+}
+
+// magicLinkHandler mails a login link, the browser gets the nonce the link is bound to
+func magicLinkHandler(c *gin.Context) {
+	var input MagicLinkRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		middleware.Fail(c, middleware.InvalidInput(err))
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(input.Email))
+
+	// Limited per email whether it exists or not, the mails go to someone's inbox
+	wait, err := takeQuota(
+		c.Request.Context(),
+		"magic:email:"+email,
+		time.Duration(viper.GetInt("magicLink.cooldown"))*time.Second,
+		viper.GetInt("magicLink.dailyLimit"),
+		24*time.Hour,
+	)
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Request Failed, Please try again later"))
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		middleware.Fail(c, middleware.TooManyRequests("Please wait before requesting another link"))
+		return
+	}
+
+	expiry := time.Duration(viper.GetInt("expiry.magicLink")) * time.Minute
+	nonce, err := randomString()
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Request Failed, Please try again later"))
+		return
+	}
+	// Set for unknown emails as well, the response must look the same
+	middleware.SetStateCookie(c, magicNonceCookie, nonce, expiry)
+
+	var user model.User
+	if err := middleware.DB(c).Model(&model.User{}).Select("user_id", "email", "is_verified", "passwordless_enabled").
+		Where("LOWER(email) = ?", email).First(&user).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logrus.WithContext(c.Request.Context()).Error("Failed to fetch user for magic link: ", err)
+		}
+		c.JSON(http.StatusOK, gin.H{"message": magicLinkMessage})
+		return
+	}
+	if !user.IsVerified || !user.PasswordlessEnabled {
+		c.JSON(http.StatusOK, gin.H{"message": magicLinkMessage})
+		return
+	}
+
+	code, err := issueOTP(c.Request.Context(), user.UserID, model.MagicLinkOTP, "", expiry)
+	if err != nil {
+		logrus.WithContext(c.Request.Context()).Error("Failed to issue magic link code: ", err)
+		middleware.Fail(c, middleware.Internal(err, "Unable to process the request, please try again later"))
+		return
+	}
+	token, err := middleware.SignClaims(middleware.MagicLinkToken, magicLinkClaims{
+		UserID:    user.UserID,
+		Code:      code,
+		NonceHash: hashNonce(nonce),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   string(model.MagicLinkOTP),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
+		},
+	})
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Unable to process the request, please try again later"))
+		return
+	}
+	job := workers.MailJob{
+		Type: "magic_link",
+		To:   user.Email,
+		Data: map[string]interface{}{
+			"link":   frontendURL() + "/login/magic?token=" + url.QueryEscape(token),
+			"expiry": viper.GetInt("expiry.magicLink"),
+		},
+	}
+	payload, _ := json.Marshal(job)
+	if err := workers.PublishJob(c.Request.Context(), payload, model.MailQueue); err != nil {
+		logrus.WithContext(c.Request.Context()).Error("Failed to enqueue magic link mail:", err)
+		middleware.Fail(c, middleware.Internal(err, "Unable to send the link, please try again later"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": magicLinkMessage})
+}
+
+// magicLinkVerifyHandler logs in with the token of the link, from the same browser that asked for it
+func magicLinkVerifyHandler(c *gin.Context) {
+	var input MagicLinkVerifyRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		middleware.Fail(c, middleware.InvalidInput(err))
+		return
+	}
+	var claims magicLinkClaims
+	if err := middleware.ParseClaims(middleware.MagicLinkToken, input.Token, &claims); err != nil || claims.Subject != string(model.MagicLinkOTP) || claims.UserID == uuid.Nil {
+		middleware.Fail(c, middleware.Unauthorized("Invalid or expired link"))
+		return
+	}
+	nonce, err := c.Cookie(magicNonceCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(hashNonce(nonce)), []byte(claims.NonceHash)) != 1 {
+		middleware.Fail(c, middleware.Unauthorized("Please open the link in the same browser you requested it from"))
+		return
+	}
+
+	if _, err := consumeOTP(c.Request.Context(), claims.UserID, model.MagicLinkOTP, claims.Code); err != nil {
+		if errors.Is(err, errOTPInvalid) || errors.Is(err, errOTPTooManyAttempts) {
+			middleware.Fail(c, middleware.Unauthorized("Invalid or expired link"))
+		} else {
+			middleware.Fail(c, middleware.Internal(err, "Database error"))
+		}
+		return
+	}
+	middleware.ClearStateCookie(c, magicNonceCookie)
+
+	// Opting out after the mail was sent stops the link as well
+	var user model.User
+	if err := middleware.DB(c).Model(&model.User{}).Select("user_id", "is_verified", "passwordless_enabled").
+		Where("user_id = ?", claims.UserID).First(&user).Error; err != nil || !user.IsVerified || !user.PasswordlessEnabled {
+		middleware.Fail(c, middleware.Unauthorized("Invalid or expired link"))
+		return
+	}
+
+	challenged, err := completeLogin(c, user.UserID)
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to generate token"))
+		return
+	}
+	if challenged {
+		c.JSON(http.StatusOK, gin.H{"message": "Enter the code from your authenticator app", "twoFactorRequired": true})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Login successful"})
+}
+
+// passwordlessHandler opts the user in or out of the magic link login
+func passwordlessHandler(c *gin.Context) {
+	userID, exist := c.Get("userID")
+	if !exist {
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
+		return
+	}
+	var input PasswordlessRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		middleware.Fail(c, middleware.InvalidInput(err))
+		return
+	}
+	if err := middleware.DB(c).Model(&model.User{}).Where("user_id = ?", userID).
+		Update("passwordless_enabled", *input.Enabled).Error; err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Unable to update the setting"))
+		return
+	}
+	if !*input.Enabled {
+		// A link already in the mailbox stops working
+		middleware.DB(c).Where("user_id = ? AND purpose = ?", userID, model.MagicLinkOTP).Delete(&model.OneTimeCode{})
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Passwordless login updated", "enabled": *input.Enabled})
+}
@@ -0,0 +1,281 @@
+package auth
+
+import (
+	"compass/middleware"
+	"compass/model"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// Cookie holding the state of an ongoing sso login, the browser must finish within oidcFlowExpiry
+const (
+	oidcFlowCookie = "oidc_flow"
+	oidcFlowExpiry = 10 * time.Minute
+)
+
+// oidcFlowClaims is what we need back in the callback to verify the IdP response
+type oidcFlowClaims struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"` // PKCE code verifier
+	Redirect string `json:"redirect"`
+	jwt.RegisteredClaims
+}
+
+// Claims we read from the IdP's id token
+type oidcIDClaims struct {
+	Email         string `json:"email"`
+	EmailVerified *bool  `json:"email_verified"`
+}
+
+var (
+	oidcMu       sync.Mutex
+	oidcProvider *oidc.Provider
+)
+
+// getOIDCProvider runs the discovery once, on failure it is retried with the next login
+func getOIDCProvider(ctx context.Context) (*oidc.Provider, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if oidcProvider != nil {
+		return oidcProvider, nil
+	}
+	provider, err := oidc.NewProvider(ctx, viper.GetString("oidc.issuer"))
+	if err != nil {
+		return nil, err
+	}
+	oidcProvider = provider
+	return oidcProvider, nil
+}
+
+func oidcConfig(provider *oidc.Provider) *oauth2.Config {
+	scopes := viper.GetStringSlice("oidc.scopes")
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+	return &oauth2.Config{
+		ClientID:     viper.GetString("oidc.clientId"),
+		ClientSecret: viper.GetString("oidc.clientSecret"),
+		RedirectURL:  viper.GetString("oidc.redirectUrl"),
+		Endpoint:     provider.Endpoint(),
+		Scopes:       scopes,
+	}
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Only same site relative paths, else the login becomes an open redirect
+func safeRedirect(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.Contains(path, "\\") {
+		return "/"
+	}
+	return path
+}
+
+// emailDomainAllowed checks the email against oidc.allowedDomains
+func emailDomainAllowed(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	return slices.ContainsFunc(viper.GetStringSlice("oidc.allowedDomains"), func(allowed string) bool {
+		return strings.ToLower(allowed) == domain
+	})
+}
+
+// oidcLoginHandler starts the authorization code flow with PKCE, redirecting to the IdP
+func oidcLoginHandler(c *gin.Context) {
+	if !viper.GetBool("oidc.enabled") {
+		middleware.Fail(c, middleware.NotFound("Single sign-on is not enabled"))
+		return
+	}
+	provider, err := getOIDCProvider(c.Request.Context())
+	if err != nil {
+		logrus.WithContext(c.Request.Context()).Error("OIDC discovery failed: ", err)
+		middleware.Fail(c, middleware.Unavailable(err, "Single sign-on is unavailable at the moment"))
+		return
+	}
+
+	state, err := randomString()
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to start login"))
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to start login"))
+		return
+	}
+	flow := oidcFlowClaims{
+		State:    state,
+		Nonce:    nonce,
+		Verifier: oauth2.GenerateVerifier(),
+		Redirect: safeRedirect(c.DefaultQuery("redirect", "/")),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(oidcFlowExpiry)),
+		},
+	}
+	flowToken, err := middleware.SignClaims(middleware.OIDCFlowToken, flow)
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to start login"))
+		return
+	}
+	middleware.SetStateCookie(c, oidcFlowCookie, flowToken, oidcFlowExpiry)
+
+	authURL := oidcConfig(provider).AuthCodeURL(state,
+		oidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(flow.Verifier),
+	)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// oidcCallbackHandler finishes the flow, links or creates the user by email and logs them in
+func oidcCallbackHandler(c *gin.Context) {
+	// Errors go back to the login page of the frontend, this is a browser navigation not an api call
+	fail := func(reason string) {
+		middleware.ClearStateCookie(c, oidcFlowCookie)
+		c.Redirect(http.StatusFound, frontendURL()+"/login?error="+url.QueryEscape(reason))
+	}
+	if !viper.GetBool("oidc.enabled") {
+		middleware.Fail(c, middleware.NotFound("Single sign-on is not enabled"))
+		return
+	}
+	if idpErr := c.Query("error"); idpErr != "" {
+		logrus.WithContext(c.Request.Context()).Warnf("OIDC login failed at the IdP: %s %s", idpErr, c.Query("error_description"))
+		fail("sso_failed")
+		return
+	}
+
+	// Verify the callback belongs to the flow started by this browser
+	flowToken, err := c.Cookie(oidcFlowCookie)
+	if err != nil {
+		fail("sso_expired")
+		return
+	}
+	var flow oidcFlowClaims
+	if err := middleware.ParseClaims(middleware.OIDCFlowToken, flowToken, &flow); err != nil || flow.State == "" || c.Query("state") != flow.State {
+		fail("sso_expired")
+		return
+	}
+
+	ctx := c.Request.Context()
+	provider, err := getOIDCProvider(ctx)
+	if err != nil {
+		logrus.WithContext(c.Request.Context()).Error("OIDC discovery failed: ", err)
+		fail("sso_unavailable")
+		return
+	}
+	oauthToken, err := oidcConfig(provider).Exchange(ctx, c.Query("code"), oauth2.VerifierOption(flow.Verifier))
+	if err != nil {
+		logrus.WithContext(c.Request.Context()).Error("OIDC code exchange failed: ", err)
+		fail("sso_failed")
+		return
+	}
+	rawIDToken, ok := oauthToken.Extra("id_token").(string)
+	if !ok {
+		logrus.WithContext(c.Request.Context()).Error("OIDC token response has no id_token")
+		fail("sso_failed")
+		return
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: viper.GetString("oidc.clientId")}).Verify(ctx, rawIDToken)
+	if err != nil || idToken.Nonce != flow.Nonce {
+		logrus.WithContext(c.Request.Context()).Warn("OIDC id token verification failed: ", err)
+		fail("sso_failed")
+		return
+	}
+	var claims oidcIDClaims
+	if err := idToken.Claims(&claims); err != nil || claims.Email == "" {
+		fail("sso_no_email")
+		return
+	}
+	if claims.EmailVerified == nil || !*claims.EmailVerified {
+		fail("sso_email_unverified")
+		return
+	}
+	if !emailDomainAllowed(claims.Email) {
+		fail("sso_domain_not_allowed")
+		return
+	}
+
+	user, err := findOrCreateSSOUser(c.Request.Context(), strings.ToLower(claims.Email))
+	if err != nil {
+		logrus.WithContext(c.Request.Context()).Error("Failed to link sso user: ", err)
+		fail("sso_failed")
+		return
+	}
+	challenged, err := completeLogin(c, user.UserID)
+	if err != nil {
+		fail("sso_failed")
+		return
+	}
+	middleware.ClearStateCookie(c, oidcFlowCookie)
+	if challenged {
+		// The IdP is the first factor, the code is still asked by us
+		c.Redirect(http.StatusFound, frontendURL()+"/login/2fa?redirect="+url.QueryEscape(flow.Redirect))
+		return
+	}
+	c.Redirect(http.StatusFound, frontendURL()+flow.Redirect)
+}
+
+// findOrCreateSSOUser links the IdP identity to the account with the same email,
+// the IdP has verified the email so a pending verification is completed as well.
+// Anyone could have signed up with an unverified email, the password of such an account
+// is dropped on linking so only the owner of the email gets in.
+func findOrCreateSSOUser(ctx context.Context, email string) (model.User, error) {
+	var user model.User
+	claimed := false
+	err := middleware.DB(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.User{}).Select("user_id", "email", "is_verified").
+			Where("LOWER(email) = ?", email).First(&user).Error
+		if err == nil {
+			if user.IsVerified {
+				return nil
+			}
+			claimed = true
+			return tx.Model(&model.User{}).Where("user_id = ?", user.UserID).
+				Updates(map[string]interface{}{"is_verified": true, "verification_token": "", "password": ""}).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		// New account, without a password, one can be set later with the forgot password flow
+		user = model.User{
+			Email:      email,
+			IsVerified: true,
+			Role:       model.UserRole,
+			Profile:    model.Profile{Email: email, Visibility: true},
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.ChangeLog{UserID: user.UserID, Action: "signup"}).Error
+	})
+	if err == nil && claimed {
+		err = middleware.RevokeAllSessions(ctx, user.UserID, uuid.Nil)
+	}
+	return user, err
+}
@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"compass/connections"
+	"compass/model"
+	"compass/testutil"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// fakeIdP is an OpenID provider with discovery, jwks and a token endpoint checking PKCE.
+// The browser part of the login is skipped, authorize hands out a code for the given identity.
+type fakeIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]idpGrant // by code
+}
+
+type idpGrant struct {
+	challenge string
+	nonce     string
+	email     string
+	verified  bool
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{key: key, grants: make(map[string]idpGrant)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"jwks_uri":                              idp.server.URL + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// authorize plays the login at the IdP for the auth url we redirected to, it returns the query of the callback
+func (idp *fakeIdP) authorize(t *testing.T, authURL string, email string, verified bool) url.Values {
+	t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("login started without PKCE: %s", authURL)
+	}
+	code := uuid.NewString()
+	idp.mu.Lock()
+	idp.grants[code] = idpGrant{challenge: query.Get("code_challenge"), nonce: query.Get("nonce"), email: email, verified: verified}
+	idp.mu.Unlock()
+	return url.Values{"code": {code}, "state": {query.Get("state")}}
+}
+
+func (idp *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	idp.mu.Lock()
+	grant, ok := idp.grants[r.PostForm.Get("code")]
+	delete(idp.grants, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            idp.server.URL,
+		"sub":            grant.email,
+		"aud":            "compass",
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          grant.nonce,
+		"email":          grant.email,
+		"email_verified": grant.verified,
+	})
+	idToken.Header["kid"] = "test"
+	signed, err := idToken.SignedString(idp.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+// newOIDCTest sets up the IdP, the db and a router with the sso routes
+func newOIDCTest(t *testing.T) (*gin.Engine, *fakeIdP) {
+	t.Helper()
+	testutil.UseDB(t)
+	idp := newFakeIdP(t)
+	testutil.SetConfig(t, map[string]any{
+		"oidc.enabled":        true,
+		"oidc.issuer":         idp.server.URL,
+		"oidc.clientId":       "compass",
+		"oidc.clientSecret":   "secret",
+		"oidc.redirectUrl":    "http://localhost:8080/api/auth/oidc/callback",
+		"oidc.allowedDomains": []string{"iitk.ac.in"},
+		"domain":              "",
+	})
+	// The discovery is cached for the process, each test has its own IdP
+	oidcProvider = nil
+	t.Cleanup(func() { oidcProvider = nil })
+
+	r := gin.New()
+	r.GET("/oidc/login", oidcLoginHandler)
+	r.GET("/oidc/callback", oidcCallbackHandler)
+	return r, idp
+}
+
+// startSSO begins a login like the browser, returns the flow cookie and where we sent it
+func startSSO(t *testing.T, r *gin.Engine) (*http.Cookie, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oidc/login?redirect=/compass", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status = %d, body %s", w.Code, w.Body)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == oidcFlowCookie {
+			return cookie, w.Header().Get("Location")
+		}
+	}
+	t.Fatal("login set no flow cookie")
+	return nil, ""
+}
+
+// finishSSO calls the callback the IdP redirects to, returns where it sent the browser and the cookies
+func finishSSO(t *testing.T, r *gin.Engine, flow *http.Cookie, query url.Values) (string, map[string]*http.Cookie) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/oidc/callback?"+query.Encode(), nil)
+	req.AddCookie(flow)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusFound {
+		t.Fatalf("callback status = %d, body %s", w.Code, w.Body)
+	}
+	cookies := make(map[string]*http.Cookie)
+	for _, cookie := range w.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	return w.Header().Get("Location"), cookies
+}
+
+func ssoLogin(t *testing.T, r *gin.Engine, idp *fakeIdP, email string) (string, map[string]*http.Cookie) {
+	t.Helper()
+	flow, authURL := startSSO(t, r)
+	return finishSSO(t, r, flow, idp.authorize(t, authURL, email, true))
+}
+
+func assertLoggedIn(t *testing.T, location string, cookies map[string]*http.Cookie) {
+	t.Helper()
+	if location != frontendURL()+"/compass" {
+		t.Fatalf("redirected to %q, want the page the login started from", location)
+	}
+	if cookies["auth_token"] == nil || cookies["auth_token"].Value == "" || cookies["refresh_token"] == nil {
+		t.Fatal("no session cookies after the login")
+	}
+}
+
+func assertFailed(t *testing.T, location string, reason string) {
+	t.Helper()
+	if location != frontendURL()+"/login?error="+reason {
+		t.Fatalf("redirected to %q, want the login page with %s", location, reason)
+	}
+}
+
+func countUsers(t *testing.T) int64 {
+	t.Helper()
+	var count int64
+	if err := connections.DB.Model(&model.User{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestOIDCCreatesUser(t *testing.T) {
+	r, idp := newOIDCTest(t)
+
+	location, cookies := ssoLogin(t, r, idp, "Student@IITK.ac.in")
+	assertLoggedIn(t, location, cookies)
+
+	var user model.User
+	if err := connections.DB.Preload("Profile").First(&user, "email = ?", "student@iitk.ac.in").Error; err != nil {
+		t.Fatalf("user not created: %v", err)
+	}
+	if !user.IsVerified || user.Password != "" || user.Role != model.UserRole {
+		t.Errorf("created user = verified %v, password %q, role %v", user.IsVerified, user.Password, user.Role)
+	}
+	if user.Profile.Email != user.Email {
+		t.Errorf("profile email = %q, want %q", user.Profile.Email, user.Email)
+	}
+
+	// The next login finds the same account
+	location, cookies = ssoLogin(t, r, idp, "student@iitk.ac.in")
+	assertLoggedIn(t, location, cookies)
+	if n := countUsers(t); n != 1 {
+		t.Errorf("%d users after the second login, want 1", n)
+	}
+}
+
+func TestOIDCLinksVerifiedUser(t *testing.T) {
+	r, idp := newOIDCTest(t)
+	existing := model.User{Email: "student@iitk.ac.in", Password: "bcrypt-hash", IsVerified: true, Role: model.UserRole}
+	if err := connections.DB.Create(&existing).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	location, cookies := ssoLogin(t, r, idp, "student@iitk.ac.in")
+	assertLoggedIn(t, location, cookies)
+
+	var user model.User
+	if err := connections.DB.First(&user, "user_id = ?", existing.UserID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Password != "bcrypt-hash" {
+		t.Error("linking a verified account dropped its password")
+	}
+	if n := countUsers(t); n != 1 {
+		t.Errorf("%d users after linking, want 1", n)
+	}
+}
+
+func TestOIDCLinksUnverifiedUser(t *testing.T) {
+	r, idp := newOIDCTest(t)
+	// Anyone may have signed up with the email, without being able to verify it
+	squatter := model.User{Email: "student@iitk.ac.in", Password: "squatter-hash", VerificationToken: "token", Role: model.UserRole}
+	if err := connections.DB.Create(&squatter).Error; err != nil {
+		t.Fatal(err)
+	}
+	session := model.Session{SessionID: uuid.New(), UserID: squatter.UserID, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := connections.DB.Create(&session).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	location, cookies := ssoLogin(t, r, idp, "student@iitk.ac.in")
+	assertLoggedIn(t, location, cookies)
+
+	var user model.User
+	if err := connections.DB.First(&user, "user_id = ?", squatter.UserID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !user.IsVerified || user.Password != "" || user.VerificationToken != "" {
+		t.Errorf("linked user = verified %v, password %q, verification token %q, want verified without both",
+			user.IsVerified, user.Password, user.VerificationToken)
+	}
+	var old model.Session
+	if err := connections.DB.First(&old, "session_id = ?", session.SessionID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if old.RevokedAt == nil {
+		t.Error("session from before the link is still active")
+	}
+}
+
+func TestOIDCRejectsOtherDomains(t *testing.T) {
+	r, idp := newOIDCTest(t)
+
+	location, _ := ssoLogin(t, r, idp, "someone@gmail.com")
+	assertFailed(t, location, "sso_domain_not_allowed")
+	if n := countUsers(t); n != 0 {
+		t.Errorf("%d users created for a foreign domain", n)
+	}
+}
+
+func TestOIDCRejectsUnverifiedEmail(t *testing.T) {
+	r, idp := newOIDCTest(t)
+
+	flow, authURL := startSSO(t, r)
+	location, _ := finishSSO(t, r, flow, idp.authorize(t, authURL, "student@iitk.ac.in", false))
+	assertFailed(t, location, "sso_email_unverified")
+	if n := countUsers(t); n != 0 {
+		t.Errorf("%d users created for an unverified email", n)
+	}
+}
+
+func TestOIDCRejectsStateMismatch(t *testing.T) {
+	r, idp := newOIDCTest(t)
+
+	flow, authURL := startSSO(t, r)
+	query := idp.authorize(t, authURL, "student@iitk.ac.in", true)
+	query.Set("state", "forged")
+	location, _ := finishSSO(t, r, flow, query)
+	assertFailed(t, location, "sso_expired")
+
+	// Without the cookie of the flow the callback is not ours either
+	_, otherURL := startSSO(t, r)
+	req := httptest.NewRequest(http.MethodGet, "/oidc/callback?"+idp.authorize(t, otherURL, "student@iitk.ac.in", true).Encode(), nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assertFailed(t, w.Header().Get("Location"), "sso_expired")
+	if n := countUsers(t); n != 0 {
+		t.Errorf("%d users created by forged callbacks", n)
+	}
+}
+
+func TestOIDCRejectsPKCEMismatch(t *testing.T) {
+	r, idp := newOIDCTest(t)
+
+	// The code was issued to the first login, the second browser has the state but not its verifier
+	_, firstURL := startSSO(t, r)
+	stolen := idp.authorize(t, firstURL, "student@iitk.ac.in", true)
+	flow, secondURL := startSSO(t, r)
+	secondState, _ := url.Parse(secondURL)
+	stolen.Set("state", secondState.Query().Get("state"))
+
+	location, cookies := finishSSO(t, r, flow, stolen)
+	assertFailed(t, location, "sso_failed")
+	if cookies["auth_token"] != nil {
+		t.Error("session issued with a code of another flow")
+	}
+	if n := countUsers(t); n != 0 {
+		t.Errorf("%d users created with a code of another flow", n)
+	}
+}
@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"compass/middleware"
+	"compass/model"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// Cookie holding the challenge of an ongoing registration or login, the browser must finish within the expiry
+const (
+	passkeyCeremonyCookie  = "passkey_ceremony"
+	passkeyCeremonyExpiry  = 5 * time.Minute
+	passkeyRegisterSubject = "passkey_register"
+	passkeyLoginSubject    = "passkey_login"
+)
+
+type passkeyCeremonyClaims struct {
+	Session webauthn.SessionData `json:"session"`
+	jwt.RegisteredClaims
+}
+
+func setPasskeyCeremony(c *gin.Context, subject string, session *webauthn.SessionData) error {
+	token, err := middleware.SignClaims(middleware.PasskeyCeremonyToken, passkeyCeremonyClaims{
+		Session: *session,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(passkeyCeremonyExpiry)),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	middleware.SetStateCookie(c, passkeyCeremonyCookie, token, passkeyCeremonyExpiry)
+	return nil
+}
+
+// passkeyCeremony reads back the session of the ceremony started by this browser
+func passkeyCeremony(c *gin.Context, subject string) (webauthn.SessionData, bool) {
+	var claims passkeyCeremonyClaims
+	token, err := c.Cookie(passkeyCeremonyCookie)
+	if err != nil {
+		return claims.Session, false
+	}
+	if err := middleware.ParseClaims(middleware.PasskeyCeremonyToken, token, &claims); err != nil || claims.Subject != subject {
+		return claims.Session, false
+	}
+	middleware.ClearStateCookie(c, passkeyCeremonyCookie)
+	return claims.Session, true
+}
+
+// passkeyRegistrationAllowed guards adding a passkey. A passkey login with user verification passes 2FA,
+// so adding one needs the current password (or a fresh login) and, when TOTP is on, a login that passed it.
+// It writes the error response and returns false when the registration must stop.
+func passkeyRegistrationAllowed(c *gin.Context, user model.User, password string) bool {
+	enabled, err := twoFactorEnabled(c.Request.Context(), user.UserID)
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Database error"))
+		return false
+	}
+	if enabled && !c.GetBool("twoFactor") {
+		middleware.Fail(c, middleware.Forbidden("Login with two factor authentication to add a passkey"))
+		return false
+	}
+	return confirmPassword(c, user, password)
+}
+
+// beginPasskeyRegistration gives the options for navigator.credentials.create()
+func beginPasskeyRegistration(c *gin.Context) {
+	userID, exist := c.Get("userID")
+	if !exist {
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
+		return
+	}
+	var input PasskeyRegistrationRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		middleware.Fail(c, middleware.InvalidInput(err))
+		return
+	}
+	user, err := loadPasskeyUser(c.Request.Context(), userID.(uuid.UUID))
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to fetch user"))
+		return
+	}
+	if !passkeyRegistrationAllowed(c, user.user, input.Password) {
+		return
+	}
+	if len(user.passkeys) >= viper.GetInt("webauthn.maxPasskeys") {
+		middleware.Fail(c, middleware.BadRequest("Passkey limit reached, remove one to add another"))
+		return
+	}
+	// Excluded so the same authenticator isn't registered twice
+	creation, session, err := relyingParty.BeginRegistration(user,
+		webauthn.WithExclusions(webauthn.Credentials(user.WebAuthnCredentials()).CredentialDescriptors()),
+	)
+	if err != nil {
+		logrus.WithContext(c.Request.Context()).Error("Failed to begin passkey registration: ", err)
+		middleware.Fail(c, middleware.Internal(err, "Failed to start passkey registration"))
+		return
+	}
+	if err := setPasskeyCeremony(c, passkeyRegisterSubject, session); err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to start passkey registration"))
+		return
+	}
+	c.JSON(http.StatusOK, creation)
+}
+
+// finishPasskeyRegistration stores the credential created by the browser, the body is its response as is
+// and the name to show in the list comes in the query (?name=Laptop)
+func finishPasskeyRegistration(c *gin.Context) {
+	userID, exist := c.Get("userID")
+	if !exist {
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
+		return
+	}
+	session, ok := passkeyCeremony(c, passkeyRegisterSubject)
+	if !ok {
+		middleware.Fail(c, middleware.BadRequest("Passkey registration expired, please try again"))
+		return
+	}
+	user, err := loadPasskeyUser(c.Request.Context(), userID.(uuid.UUID))
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to fetch user"))
+		return
+	}
+	credential, err := relyingParty.FinishRegistration(user, session, c.Request)
+	if err != nil {
+		logrus.WithContext(c.Request.Context()).Warn("Passkey registration failed: ", err)
+		middleware.Fail(c, middleware.BadRequest("Passkey registration failed"))
+		return
+	}
+
+	name := strings.TrimSpace(c.Query("name"))
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > 50 {
+		name = name[:50]
+	}
+	passkey := model.Passkey{
+		UserID:       user.user.UserID,
+		Name:         name,
+		CredentialID: credential.ID,
+		Credential:   *credential,
+	}
+	if err := middleware.DB(c).Create(&passkey).Error; err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			middleware.Fail(c, middleware.Conflict("This passkey is already registered"))
+			return
+		}
+		middleware.Fail(c, middleware.Internal(err, "Failed to save passkey"))
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "Passkey added", "passkey": passkey})
+}
+
+// listPasskeys provides the passkeys of the user
+func listPasskeys(c *gin.Context) {
+	userID, exist := c.Get("userID")
+	if !exist {
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
+		return
+	}
+	var passkeys []model.Passkey
+	if err := middleware.DB(c).Where("user_id = ?", userID.(uuid.UUID)).Order("created_at").Find(&passkeys).Error; err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to fetch passkeys"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"passkeys": passkeys})
+}
+
+// deletePasskey removes a passkey of the user, it can't be used to login after this
+func deletePasskey(c *gin.Context) {
+	userID, exist := c.Get("userID")
+	if !exist {
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
+		return
+	}
+	passkeyID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		middleware.Fail(c, middleware.BadRequest("Invalid passkey ID format"))
+		return
+	}
+	result := middleware.DB(c).Where("id = ? AND user_id = ?", passkeyID, userID.(uuid.UUID)).Delete(&model.Passkey{})
+	if result.Error != nil {
+		middleware.Fail(c, middleware.Internal(result.Error, "Failed to remove passkey"))
+		return
+	}
+	if result.RowsAffected == 0 {
+		middleware.Fail(c, middleware.NotFound("Passkey not found"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Passkey removed"})
+}
+
+// beginPasskeyLogin gives the options for navigator.credentials.get(), no email needed as passkeys are discoverable
+func beginPasskeyLogin(c *gin.Context) {
+	assertion, session, err := relyingParty.BeginDiscoverableLogin()
+	if err != nil {
+		logrus.WithContext(c.Request.Context()).Error("Failed to begin passkey login: ", err)
+		middleware.Fail(c, middleware.Internal(err, "Failed to start login"))
+		return
+	}
+	if err := setPasskeyCeremony(c, passkeyLoginSubject, session); err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to start login"))
+		return
+	}
+	c.JSON(http.StatusOK, assertion)
+}
+
+// finishPasskeyLogin verifies the assertion of the browser (the body as is) and logs the owner of the passkey in
+func finishPasskeyLogin(c *gin.Context) {
+	session, ok := passkeyCeremony(c, passkeyLoginSubject)
+	if !ok {
+		middleware.Fail(c, middleware.BadRequest("Passkey login expired, please try again"))
+		return
+	}
+
+	var passkey model.Passkey
+	findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+		if err := middleware.DB(c).Where("credential_id = ?", rawID).First(&passkey).Error; err != nil {
+			return nil, err
+		}
+		if ownerID, err := uuid.FromBytes(userHandle); err != nil || ownerID != passkey.UserID {
+			return nil, errors.New("user handle does not match the passkey")
+		}
+		return loadPasskeyUser(c.Request.Context(), passkey.UserID)
+	}
+	user, credential, err := relyingParty.FinishPasskeyLogin(findUser, session, c.Request)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logrus.WithContext(c.Request.Context()).Warn("Passkey login failed: ", err)
+		}
+		middleware.Fail(c, middleware.Unauthorized("Passkey login failed"))
+		return
+	}
+	// The counter went back, two copies of the private key are in use
+	if credential.Authenticator.CloneWarning {
+		logrus.WithContext(c.Request.Context()).Warnf("Possibly cloned passkey %d of user %s rejected", passkey.ID, passkey.UserID)
+		middleware.Fail(c, middleware.Unauthorized("Passkey login failed"))
+		return
+	}
+	now := time.Now()
+	// A struct so the credential goes through its json serializer, a map would pass it to the driver as is
+	if err := middleware.DB(c).Model(&passkey).Updates(model.Passkey{
+		Credential: *credential,
+		LastUsedAt: &now,
+	}).Error; err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Database error"))
+		return
+	}
+
+	owner := user.(passkeyUser).user
+	if !owner.IsVerified {
+		middleware.Fail(c, middleware.Unauthorized("Email not verified"))
+		return
+	}
+	// The passkey is the device and, when the user verified with a pin or biometric, the second factor as well
+	if credential.Flags.UserVerified {
+		if err := issueSession(c, owner.UserID, true); err != nil {
+			middleware.Fail(c, middleware.Internal(err, "Failed to generate token"))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Login successful"})
+		return
+	}
+	challenged, err := completeLogin(c, owner.UserID)
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to generate token"))
+		return
+	}
+	if challenged {
+		c.JSON(http.StatusOK, gin.H{"message": "Enter the code from your authenticator app", "twoFactorRequired": true})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Login successful"})
+}
@@ -0,0 +1,377 @@
+package auth
+
+import (
+	"bytes"
+	"compass/connections"
+	"compass/middleware"
+	"compass/model"
+	"compass/testutil"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var b64 = base64.RawURLEncoding
+
+// softAuthenticator is a passkey in software: a P-256 key, its credential id and the signature counter
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	counter      uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{key: key, credentialID: id}
+}
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// authData is the authenticator data for the rpId, with the attested credential when registering
+func (a *softAuthenticator) authData(t *testing.T, flags byte, attested bool) []byte {
+	t.Helper()
+	rpIDHash := sha256.Sum256([]byte(viper.GetString("webauthn.rpId")))
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.counter)
+	if !attested {
+		return data
+	}
+	data = append(data, make([]byte, 16)...) // aaguid, none
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+	data = append(data, a.credentialID...)
+	x, y := make([]byte, 32), make([]byte, 32)
+	a.key.PublicKey.X.FillBytes(x)
+	a.key.PublicKey.Y.FillBytes(y)
+	// COSE EC2 key: kty EC2, alg ES256, crv P-256
+	publicKey, err := cbor.Marshal(map[int]any{1: 2, 3: -7, -1: 1, -2: x, -3: y})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(data, publicKey...)
+}
+
+func clientData(t *testing.T, ceremony string, challenge string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    viper.GetStringSlice("webauthn.origins")[0],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// create answers navigator.credentials.create() with a none attestation
+func (a *softAuthenticator) create(t *testing.T, challenge string) []byte {
+	t.Helper()
+	attestation, err := cbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(t, flagUserPresent|flagUserVerified|flagAttested, true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]any{
+		"id":    b64.EncodeToString(a.credentialID),
+		"rawId": b64.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(clientData(t, "webauthn.create", challenge)),
+			"attestationObject": b64.EncodeToString(attestation),
+		},
+	})
+	return body
+}
+
+// get answers navigator.credentials.get(), the counter goes up with every use like on a real device
+func (a *softAuthenticator) get(t *testing.T, challenge string, userHandle []byte) []byte {
+	t.Helper()
+	a.counter++
+	authData := a.authData(t, flagUserPresent|flagUserVerified, false)
+	clientDataJSON := clientData(t, "webauthn.get", challenge)
+	clientHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]any{
+		"id":    b64.EncodeToString(a.credentialID),
+		"rawId": b64.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(clientDataJSON),
+			"authenticatorData": b64.EncodeToString(authData),
+			"signature":         b64.EncodeToString(signature),
+			"userHandle":        b64.EncodeToString(userHandle),
+		},
+	})
+	return body
+}
+
+// newPasskeyTest sets up the db and a router with the passkey routes, the user of X-Test-User is logged in
+// and X-Test-2FA tells if the login passed the second factor
+func newPasskeyTest(t *testing.T) *gin.Engine {
+	t.Helper()
+	testutil.UseDB(t)
+	loggedIn := func(c *gin.Context) {
+		c.Set("userID", uuid.MustParse(c.GetHeader("X-Test-User")))
+		c.Set("twoFactor", c.GetHeader("X-Test-2FA") == "true")
+		c.Next()
+	}
+	r := gin.New()
+	r.Use(middleware.Errors())
+	r.POST("/passkeys/register/begin", loggedIn, beginPasskeyRegistration)
+	r.POST("/passkeys/register/finish", loggedIn, finishPasskeyRegistration)
+	r.POST("/passkeys/login/begin", beginPasskeyLogin)
+	r.POST("/passkeys/login/finish", finishPasskeyLogin)
+	r.DELETE("/passkeys/:id", loggedIn, deletePasskey)
+	return r
+}
+
+const testPassword = "correct horse battery staple"
+
+func createVerifiedUser(t *testing.T, email string) model.User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := model.User{Email: email, Password: string(hash), IsVerified: true, Role: model.UserRole}
+	if err := connections.DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// beginRegistration starts the registration of a passkey for the user with the password given
+func beginRegistration(r *gin.Engine, user model.User, password string, twoFactor bool) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(PasskeyRegistrationRequest{Password: password})
+	begin := httptest.NewRequest(http.MethodPost, "/passkeys/register/begin", bytes.NewReader(body))
+	begin.Header.Set("Content-Type", "application/json")
+	begin.Header.Set("X-Test-User", user.UserID.String())
+	if twoFactor {
+		begin.Header.Set("X-Test-2FA", "true")
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, begin)
+	return w
+}
+
+// ceremony runs begin and finish of a registration or login, answer builds the finish body from the challenge
+func ceremony(t *testing.T, r *gin.Engine, path string, user *model.User, answer func(challenge string) []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var w *httptest.ResponseRecorder
+	if user != nil {
+		w = beginRegistration(r, *user, testPassword, true)
+	} else {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path+"/begin", nil))
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s/begin status = %d, body %s", path, w.Code, w.Body)
+	}
+	var options struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &options); err != nil || options.PublicKey.Challenge == "" {
+		t.Fatalf("%s/begin gave no challenge: %s", path, w.Body)
+	}
+
+	finish := httptest.NewRequest(http.MethodPost, path+"/finish", bytes.NewReader(answer(options.PublicKey.Challenge)))
+	finish.Header.Set("Content-Type", "application/json")
+	if user != nil {
+		finish.Header.Set("X-Test-User", user.UserID.String())
+	}
+	for _, cookie := range w.Result().Cookies() {
+		finish.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, finish)
+	return w
+}
+
+func registerPasskey(t *testing.T, r *gin.Engine, user model.User, authenticator *softAuthenticator) {
+	t.Helper()
+	w := ceremony(t, r, "/passkeys/register", &user, func(challenge string) []byte {
+		return authenticator.create(t, challenge)
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("registration status = %d, body %s", w.Code, w.Body)
+	}
+}
+
+func loginWithPasskey(t *testing.T, r *gin.Engine, authenticator *softAuthenticator, userHandle []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	return ceremony(t, r, "/passkeys/login", nil, func(challenge string) []byte {
+		return authenticator.get(t, challenge, userHandle)
+	})
+}
+
+func hasSessionCookie(w *httptest.ResponseRecorder) bool {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "auth_token" && cookie.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPasskeyRegisterAndLogin(t *testing.T) {
+	r := newPasskeyTest(t)
+	user := createVerifiedUser(t, "student@iitk.ac.in")
+	authenticator := newSoftAuthenticator(t)
+
+	registerPasskey(t, r, user, authenticator)
+	var stored model.Passkey
+	if err := connections.DB.First(&stored, "user_id = ?", user.UserID).Error; err != nil {
+		t.Fatalf("passkey not stored: %v", err)
+	}
+	if !bytes.Equal(stored.CredentialID, authenticator.credentialID) {
+		t.Error("stored credential id differs from the authenticator's")
+	}
+
+	for range 2 {
+		w := loginWithPasskey(t, r, authenticator, user.UserID[:])
+		if w.Code != http.StatusOK || !hasSessionCookie(w) {
+			t.Fatalf("login status = %d, body %s", w.Code, w.Body)
+		}
+	}
+	if err := connections.DB.First(&stored, stored.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Credential.Authenticator.SignCount != authenticator.counter || stored.LastUsedAt == nil {
+		t.Errorf("stored sign count = %d, last used %v, want %d and set", stored.Credential.Authenticator.SignCount, stored.LastUsedAt, authenticator.counter)
+	}
+}
+
+func TestPasskeyLoginRejectsOtherUserHandle(t *testing.T) {
+	r := newPasskeyTest(t)
+	owner := createVerifiedUser(t, "owner@iitk.ac.in")
+	other := createVerifiedUser(t, "other@iitk.ac.in")
+	authenticator := newSoftAuthenticator(t)
+	registerPasskey(t, r, owner, authenticator)
+
+	// A valid signature of the owner's key, claiming to be the other account
+	w := loginWithPasskey(t, r, authenticator, other.UserID[:])
+	if w.Code != http.StatusUnauthorized || hasSessionCookie(w) {
+		t.Fatalf("login with another user handle status = %d, body %s", w.Code, w.Body)
+	}
+}
+
+func TestPasskeyLoginRejectsClone(t *testing.T) {
+	r := newPasskeyTest(t)
+	user := createVerifiedUser(t, "student@iitk.ac.in")
+	authenticator := newSoftAuthenticator(t)
+	registerPasskey(t, r, user, authenticator)
+
+	authenticator.counter = 5
+	if w := loginWithPasskey(t, r, authenticator, user.UserID[:]); w.Code != http.StatusOK {
+		t.Fatalf("login status = %d, body %s", w.Code, w.Body)
+	}
+	// A copy of the key with an older counter
+	authenticator.counter = 2
+	w := loginWithPasskey(t, r, authenticator, user.UserID[:])
+	if w.Code != http.StatusUnauthorized || hasSessionCookie(w) {
+		t.Fatalf("login with a lower counter status = %d, body %s", w.Code, w.Body)
+	}
+	var stored model.Passkey
+	if err := connections.DB.First(&stored, "user_id = ?", user.UserID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Credential.Authenticator.SignCount != 6 {
+		t.Errorf("stored sign count = %d after the rejected login, want 6", stored.Credential.Authenticator.SignCount)
+	}
+}
+
+func TestPasskeyRegistrationNeedsPassword(t *testing.T) {
+	r := newPasskeyTest(t)
+	user := createVerifiedUser(t, "student@iitk.ac.in")
+
+	if w := beginRegistration(r, user, "", false); w.Code != http.StatusBadRequest {
+		t.Errorf("registration without the password status = %d, body %s", w.Code, w.Body)
+	}
+	if w := beginRegistration(r, user, "wrong password", false); w.Code != http.StatusUnauthorized {
+		t.Errorf("registration with a wrong password status = %d, body %s", w.Code, w.Body)
+	}
+	if w := beginRegistration(r, user, testPassword, false); w.Code != http.StatusOK {
+		t.Errorf("registration with the password status = %d, body %s", w.Code, w.Body)
+	}
+}
+
+// A passkey login passes 2FA, so a login which did not can't add one to get around the TOTP
+func TestPasskeyRegistrationNeedsTwoFactor(t *testing.T) {
+	r := newPasskeyTest(t)
+	user := createVerifiedUser(t, "student@iitk.ac.in")
+	if err := connections.DB.Create(&model.TwoFactor{UserID: user.UserID, Secret: "JBSWY3DPEHPK3PXP", Enabled: true}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if w := beginRegistration(r, user, testPassword, false); w.Code != http.StatusForbidden {
+		t.Errorf("registration from a login without 2FA status = %d, body %s", w.Code, w.Body)
+	}
+	if w := beginRegistration(r, user, testPassword, true); w.Code != http.StatusOK {
+		t.Errorf("registration from a login with 2FA status = %d, body %s", w.Code, w.Body)
+	}
+}
+
+func TestDeletePasskey(t *testing.T) {
+	r := newPasskeyTest(t)
+	user := createVerifiedUser(t, "student@iitk.ac.in")
+	authenticator := newSoftAuthenticator(t)
+	registerPasskey(t, r, user, authenticator)
+	var stored model.Passkey
+	if err := connections.DB.First(&stored, "user_id = ?", user.UserID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	remove := func(id string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/passkeys/"+id, nil)
+		req.Header.Set("X-Test-User", user.UserID.String())
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := remove("abc"); code != http.StatusBadRequest {
+		t.Errorf("delete with a non numeric id status = %d, want 400", code)
+	}
+	if code := remove(strconv.FormatUint(uint64(stored.ID), 10)); code != http.StatusOK {
+		t.Errorf("delete status = %d, want 200", code)
+	}
+	if code := remove(strconv.FormatUint(uint64(stored.ID), 10)); code != http.StatusNotFound {
+		t.Errorf("second delete status = %d, want 404", code)
+	}
+	if w := loginWithPasskey(t, r, authenticator, user.UserID[:]); w.Code != http.StatusUnauthorized {
+		t.Errorf("login with the removed passkey status = %d, body %s", w.Code, w.Body)
+	}
+}
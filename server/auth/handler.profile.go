@@ -4,6 +4,8 @@ import (
 	"compass/connections"
 	"compass/middleware"
 	"compass/model"
+	"compass/student"
+	"context"
 	"errors"
 	"net/http"
 
@@ -15,6 +17,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
@@ -22,38 +25,51 @@ import (
 func updatePassword(c *gin.Context) {
 	var input UpdatePasswordRequest
 	var user model.User
-	var err error
-	var newPasswordHash []byte
 
 	// TODO: Many functions have this repetition, extract out.
 	// Request Validation
 	userID, exist := c.Get("userID")
 	if !exist {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
 		return
 	}
 	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		middleware.Fail(c, middleware.InvalidInput(err))
+		return
+	}
+	if err := middleware.DB(c).Model(&model.User{}).Select("user_id", "email", "password").
+		Where("user_id = ?", userID.(uuid.UUID)).First(&user).Error; err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to fetch user"))
+		return
+	}
+	if !confirmPassword(c, user, input.CurrentPassword) {
+		return
+	}
+	if input.NewPassword == input.CurrentPassword {
+		respondPasswordProblems(c, "password", []string{"Must be different from the current password"})
+		return
+	}
+	if problems := checkPassword(c.Request.Context(), input.NewPassword, user.Email); len(problems) > 0 {
+		respondPasswordProblems(c, "password", problems)
 		return
 	}
-	// find the current user, we are sure it exist
-	connections.DB.Model(&model.User{}).Where("user_id = ?", userID.(uuid.UUID)).First(&user)
 
-	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.NewPassword)) != nil {
-		if len(input.NewPassword) < 8 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 8 characters"})
-			return
-		}
-		if newPasswordHash, err = bcrypt.GenerateFromPassword([]byte(input.NewPassword), bcrypt.DefaultCost); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable create new password"})
-		}
+	newPasswordHash, err := bcrypt.GenerateFromPassword([]byte(input.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Unable create new password"))
+		return
 	}
-	if err := connections.DB.Model(&model.User{}).
+	if err := middleware.DB(c).Model(&model.User{}).
 		Where("user_id = ?", userID.(uuid.UUID)).
 		Update("password", string(newPasswordHash)).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed update password"})
+		middleware.Fail(c, middleware.Internal(err, "Failed update password"))
 		return
 	}
+	// Other devices may be logged in with the old password, this one stays
+	keep, _ := middleware.CurrentSessionID(c)
+	if err := middleware.RevokeAllSessions(c.Request.Context(), user.UserID, keep); err != nil {
+		logrus.WithContext(c.Request.Context()).Errorf("Failed to revoke sessions of user %s after password change: %v", user.UserID, err)
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Password updated successfully"})
 }
 
@@ -61,59 +77,46 @@ func updatePassword(c *gin.Context) {
 // 	// TODO: set up for images, for image upload, if the similarity is > 90,can ignore it (can think)
 // }
 
-func verifyProfile(c *gin.Context, profileData model.Profile) bool {
-	// OA's verification route, do not take name input, but returns name upon verification
-	// Creating the paramkey string
-	paramkey := fmt.Sprintf("%s:%s:%s:%s", profileData.RollNo, profileData.Course, profileData.Dept, profileData.Email)
+// studentVerifier is set up by Init
+var studentVerifier student.Verifier
 
-	// Send request to verify student data
-	client := &http.Client{Timeout: 10 * time.Second}
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s?paramkey=%s", viper.GetString("oa.url"), paramkey), nil)
+func mustStudentVerifier() student.Verifier {
+	verifier, err := student.NewVerifier()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create verification request"})
-		return false
+		logrus.Fatal("Failed to set up the student verifier: ", err)
 	}
-	req.Header.Set("x-api-key", viper.GetString("oa.key"))
-	req.Header.Set("Accept", "application/json")
-	resp, err := client.Do(req)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to call OA API"})
-		return false
-	}
-	defer resp.Body.Close()
+	return verifier
+}
 
-	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == http.StatusForbidden {
-			logrus.Errorf("OA Token expired or missing, Urgent action required, request new or check viper env")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Programming club's oa token expired, we are working to resolve it as soon as possible"})
-		} else {
-			logrus.Error("OA API ERROR, with status code: ", resp.StatusCode)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Some error occurred in profile verification, please try again later."})
-		}
-		return false
+// verifyProfile checks the details with the institute records, OA does not take the name as input but returns it on success
+func verifyProfile(ctx context.Context, profileData model.Profile) error {
+	record, err := studentVerifier.Verify(ctx, student.Query{
+		RollNo: profileData.RollNo,
+		Course: profileData.Course,
+		Dept:   profileData.Dept,
+		Email:  profileData.Email,
+	})
+	if err != nil {
+		return err
 	}
-
-	var apiResp CCResponse
-	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse OA API response"})
-		return false
+	if record.Name != profileData.Name {
+		return student.ErrNotVerified
 	}
+	return nil
+}
 
-	// Checking Status of verification
-	if apiResp.Status != nil {
-		if *apiResp.Status != "true" || (profileData.Name != *apiResp.Name) {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Please once verify you data. It should be exactly same as printed on your ID card or displayed in IITK APP",
-			})
-			return false
-		}
-	} else {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Student data not verified",
-		})
-		return false
+// respondVerificationError maps the verifier errors to the response
+func respondVerificationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, student.ErrNotVerified):
+		middleware.Fail(c, middleware.BadRequest("Please once verify you data. It should be exactly same as printed on your ID card or displayed in IITK APP"))
+	case errors.Is(err, student.ErrUnauthorized):
+		logrus.WithContext(c.Request.Context()).Errorf("OA Token expired or missing, Urgent action required, request new or check viper env")
+		middleware.Fail(c, middleware.Internal(err, "Programming club's oa token expired, we are working to resolve it as soon as possible"))
+	default:
+		logrus.WithContext(c.Request.Context()).Error("OA API ERROR: ", err)
+		middleware.Fail(c, middleware.Unavailable(err, "Some error occurred in profile verification, please try again later."))
 	}
-	return true
 }
 
 func updateProfile(c *gin.Context) {
@@ -123,20 +126,20 @@ func updateProfile(c *gin.Context) {
 	// Request Validation
 	userID, exist := c.Get("userID")
 	if !exist {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
 		return
 	}
 	var user model.User
-	if connections.DB.
+	if middleware.DB(c).
 		Model(&model.User{}).
 		Select("user_id, email").
 		Preload("Profile").
 		First(&user, "user_id = ?", userID.(uuid.UUID)).Error != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User does not exist"})
+		middleware.Fail(c, middleware.BadRequest("User does not exist"))
 		return
 	}
 	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		middleware.Fail(c, middleware.InvalidInput(err))
 		return
 	}
 	// find the current user, we are sure it exist
@@ -160,7 +163,8 @@ func updateProfile(c *gin.Context) {
 		user.Profile.Dept != profileData.Dept ||
 		user.Profile.Course != profileData.Course {
 		// Verify from oa
-		if !verifyProfile(c, profileData) {
+		if err := verifyProfile(c.Request.Context(), profileData); err != nil {
+			respondVerificationError(c, err)
 			return
 		}
 
@@ -168,7 +172,7 @@ func updateProfile(c *gin.Context) {
 
 	// TODO: Test it
 	// Update into db
-	if err := connections.DB.Transaction(func(tx *gorm.DB) error {
+	if err := middleware.DB(c).Transaction(func(tx *gorm.DB) error {
 		// Update or Create the Profile // 'tx' here instead of 'connections.DB' for one single step
 		if err := tx.
 			Where(model.Profile{UserID: userID.(uuid.UUID)}).
@@ -194,7 +198,7 @@ func updateProfile(c *gin.Context) {
 
 		return nil
 	}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		middleware.Fail(c, middleware.Internal(err, "Failed to update profile"))
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Profile updated successfully"})
@@ -205,10 +209,10 @@ func getProfileHandler(c *gin.Context) {
 	var user model.User
 	userID, exist := c.Get("userID")
 	if !exist {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
 		return
 	}
-	err := connections.DB.
+	err := middleware.DB(c).
 		Model(&model.User{}).
 		Preload("Profile").
 		Preload("ContributedLocations", connections.RecentFiveLocations).
@@ -218,10 +222,10 @@ func getProfileHandler(c *gin.Context) {
 		Where("user_id = ?", userID.(uuid.UUID)).Omit("password").First(&user).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User does not exist"})
+			middleware.Fail(c, middleware.NotFound("User does not exist"))
 			middleware.ClearAuthCookie(c)
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to fetch profile at the moment"})
+			middleware.Fail(c, middleware.Internal(err, "Unable to fetch profile at the moment"))
 		}
 		return
 	}
@@ -232,36 +236,36 @@ func getProfileHandler(c *gin.Context) {
 func autoC(c *gin.Context) {
 	userID, exist := c.Get("userID")
 	if !exist {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
 		return
 	}
 
 	var user model.User
-	if err := connections.DB.
+	if err := middleware.DB(c).
 		Model(&model.User{}).
 		Select("email").
 		Where("user_id = ?", userID.(uuid.UUID)).
 		First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			middleware.Fail(c, middleware.NotFound("User not found"))
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user details"})
+			middleware.Fail(c, middleware.Internal(err, "Failed to fetch user details"))
 		}
 		return
 	}
 
 	automationServerURL := viper.GetString("automation.url")
 	if automationServerURL == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Auth server configuration missing"})
+		middleware.Fail(c, middleware.Internal(errors.New("automation.url is not set"), "Auth server configuration missing"))
 		return
 	}
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := &http.Client{Timeout: 10 * time.Second, Transport: otelhttp.NewTransport(http.DefaultTransport)}
 	reqURL := fmt.Sprintf("%s/getDetails?email=%s", automationServerURL, user.Email)
 
-	req, err := http.NewRequest("GET", reqURL, nil)
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, reqURL, nil)
 	if err != nil {
-		logrus.WithError(err).Error("Failed to create automation request")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
+		logrus.WithContext(c.Request.Context()).WithError(err).Error("Failed to create automation request")
+		middleware.Fail(c, middleware.Internal(err, "Failed to create request"))
 		return
 	}
 
@@ -270,8 +274,8 @@ func autoC(c *gin.Context) {
 
 	resp, err := client.Do(req)
 	if err != nil {
-		logrus.WithError(err).Error("Failed to call automation server")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to call automation server"})
+		logrus.WithContext(c.Request.Context()).WithError(err).Error("Failed to call automation server")
+		middleware.Fail(c, middleware.Internal(err, "Failed to call automation server"))
 		return
 	}
 	defer resp.Body.Close()
@@ -279,20 +283,20 @@ func autoC(c *gin.Context) {
 	if resp.StatusCode != http.StatusOK {
 		switch resp.StatusCode {
 		case http.StatusUnauthorized:
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed"})
+			middleware.Fail(c, middleware.Unauthorized("Authentication failed"))
 		case http.StatusNotFound:
-			c.JSON(http.StatusNotFound, gin.H{"error": "Not Found."})
+			middleware.Fail(c, middleware.NotFound("Not Found."))
 		default:
-			logrus.WithField("status", resp.StatusCode).Error("Automation server returned error")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Automation Server returned error"})
+			logrus.WithContext(c.Request.Context()).WithField("status", resp.StatusCode).Error("Automation server returned error")
+			middleware.Fail(c, middleware.Internal(fmt.Errorf("automation server returned %s", resp.Status), "Automation Server returned error"))
 		}
 		return
 	}
 
 	var studentDetails StudentDetails
 	if err := json.NewDecoder(resp.Body).Decode(&studentDetails); err != nil {
-		logrus.WithError(err).Error("Failed to parse auth server response")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse student details"})
+		logrus.WithContext(c.Request.Context()).WithError(err).Error("Failed to parse auth server response")
+		middleware.Fail(c, middleware.Internal(err, "Failed to parse student details"))
 		return
 	}
 
@@ -1,7 +1,7 @@
 package auth
 
 import (
-	"compass/connections"
+	"compass/middleware"
 	"compass/model"
 	"io"
 	"net/http"
@@ -17,20 +17,20 @@ import (
 func UploadProfileImage(c *gin.Context) {
 	userIDRaw, exists := c.Get("userID")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
 		return
 	}
 	userID := userIDRaw.(uuid.UUID)
 
 	// Parsing form
 	if err := c.Request.ParseMultipartForm(10 << 20); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form"})
+		middleware.Fail(c, middleware.BadRequest("Failed to parse form"))
 		return
 	}
 
 	file, header, err := c.Request.FormFile("profileImage")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Profile image is required"})
+		middleware.Fail(c, middleware.BadRequest("Profile image is required"))
 		return
 	}
 	defer file.Close()
@@ -41,7 +41,7 @@ func UploadProfileImage(c *gin.Context) {
 	uploadDir := filepath.Join(cwd, "assets", "pfp")
 
 	if err := os.MkdirAll(uploadDir, os.ModePerm); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload directory"})
+		middleware.Fail(c, middleware.Internal(err, "Failed to create upload directory"))
 		return
 	}
 
@@ -52,13 +52,13 @@ func UploadProfileImage(c *gin.Context) {
 
 	out, err := os.Create(fullPath)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		middleware.Fail(c, middleware.Internal(err, "Failed to save file"))
 		return
 	}
 	defer out.Close()
 
 	if _, err := io.Copy(out, file); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write file"})
+		middleware.Fail(c, middleware.Internal(err, "Failed to write file"))
 		return
 	}
 
@@ -67,10 +67,10 @@ func UploadProfileImage(c *gin.Context) {
 
 	// TODO: If here any error occurs the image is saved, but no data about it.
 	// Saving relative path to DB
-	if err := connections.DB.Model(&model.User{}).
+	if err := middleware.DB(c).Model(&model.User{}).
 		Where("user_id = ?", userID).
 		Update("profile_pic", relativePath).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile pic"})
+		middleware.Fail(c, middleware.Internal(err, "Failed to update profile pic"))
 		return
 	}
 
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"compass/middleware"
+	"compass/model"
+	"compass/workers"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Same response whether the account exists or not, so this route can't be used to find registered emails
+const forgotPasswordMessage = "If an account exists for this email, a reset code has been sent to it."
+
+func forgotPasswordHandler(c *gin.Context) {
+	var input ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		middleware.Fail(c, middleware.InvalidInput(err))
+		return
+	}
+
+	// Limited per email before the lookup, so unknown emails get the same 429 and no inbox gets flooded.
+	// Every new code resets the attempts, the cap also bounds the guesses.
+	wait, err := takeQuota(
+		c.Request.Context(),
+		"reset:email:"+strings.ToLower(strings.TrimSpace(input.Email)),
+		time.Duration(viper.GetInt("passwordReset.cooldown"))*time.Second,
+		viper.GetInt("passwordReset.dailyLimit"),
+		24*time.Hour,
+	)
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Unable to process the request, please try again later"))
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		middleware.Fail(c, middleware.TooManyRequests("Please wait before requesting another code"))
+		return
+	}
+
+	var user model.User
+	if err := middleware.DB(c).Model(&model.User{}).Select("user_id", "email", "is_verified").
+		Where("email = ?", input.Email).First(&user).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logrus.WithContext(c.Request.Context()).Error("Failed to fetch user for password reset: ", err)
+		}
+		c.JSON(http.StatusOK, gin.H{"message": forgotPasswordMessage})
+		return
+	}
+	// Unverified accounts should use the verification flow instead
+	if !user.IsVerified {
+		c.JSON(http.StatusOK, gin.H{"message": forgotPasswordMessage})
+		return
+	}
+
+	expiry := viper.GetInt("expiry.passwordReset")
+	code, err := issueOTP(c.Request.Context(), user.UserID, model.PasswordResetOTP, "", time.Duration(expiry)*time.Minute)
+	if err != nil {
+		logrus.WithContext(c.Request.Context()).Error("Failed to issue password reset code: ", err)
+		middleware.Fail(c, middleware.Internal(err, "Unable to process the request, please try again later"))
+		return
+	}
+
+	job := workers.MailJob{
+		Type: "password_reset",
+		To:   user.Email,
+		Data: map[string]interface{}{
+			"token":  fmt.Sprintf("%s-%s", code[:3], code[3:]),
+			"link":   fmt.Sprintf("%s/reset?email=%s", frontendURL(), url.QueryEscape(user.Email)),
+			"expiry": expiry,
+		},
+	}
+	payload, _ := json.Marshal(job)
+	if err := workers.PublishJob(c.Request.Context(), payload, model.MailQueue); err != nil {
+		logrus.WithContext(c.Request.Context()).Error("Failed to enqueue password reset mail:", err)
+		middleware.Fail(c, middleware.Internal(err, "Unable to send the reset code, please try again later"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": forgotPasswordMessage})
+}
+
+func resetPasswordHandler(c *gin.Context) {
+	var input ResetPasswordRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		middleware.Fail(c, middleware.InvalidInput(err))
+		return
+	}
+
+	// Before the code is used up, so a rejected password can be retried with the same code
+	if problems := checkPassword(c.Request.Context(), input.Password, input.Email); len(problems) > 0 {
+		respondPasswordProblems(c, "password", problems)
+		return
+	}
+
+	var user model.User
+	if err := middleware.DB(c).Model(&model.User{}).Select("user_id").
+		Where("email = ? AND is_verified = ?", input.Email, true).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			middleware.Fail(c, middleware.BadRequest("Invalid or expired code"))
+		} else {
+			middleware.Fail(c, middleware.Internal(err, "Database error"))
+		}
+		return
+	}
+
+	// Accept the code in the same format as mailed, 123-456
+	if _, err := consumeOTP(c.Request.Context(), user.UserID, model.PasswordResetOTP, normalizeOTP(input.Token)); err != nil {
+		switch {
+		case errors.Is(err, errOTPTooManyAttempts):
+			middleware.Fail(c, middleware.TooManyRequests("Too many wrong attempts, please request a new code"))
+		case errors.Is(err, errOTPInvalid):
+			middleware.Fail(c, middleware.BadRequest("Invalid or expired code"))
+		default:
+			middleware.Fail(c, middleware.Internal(err, "Database error"))
+		}
+		return
+	}
+
+	hashPass, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Unable to reset password"))
+		return
+	}
+	if err := middleware.DB(c).Model(&model.User{}).
+		Where("user_id = ?", user.UserID).
+		Update("password", string(hashPass)).Error; err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Unable to reset password"))
+		return
+	}
+	// Log out every device, someone else may have had the old password
+	if err := middleware.RevokeAllSessions(c.Request.Context(), user.UserID, uuid.Nil); err != nil {
+		logrus.WithContext(c.Request.Context()).Errorf("Failed to revoke sessions of user %s after password reset: %v", user.UserID, err)
+	}
+	middleware.ClearAuthCookie(c)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successful, please login with your new password."})
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"compass/middleware"
+	"compass/model"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type sessionResponse struct {
+	model.Session
+	Current bool `json:"current"`
+}
+
+// listSessions provides the devices the user is logged in on
+func listSessions(c *gin.Context) {
+	userID, exist := c.Get("userID")
+	if !exist {
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
+		return
+	}
+	var sessions []model.Session
+	if err := middleware.DB(c).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID.(uuid.UUID), time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error; err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to fetch sessions"))
+		return
+	}
+	currentID, _ := middleware.CurrentSessionID(c)
+	response := make([]sessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, sessionResponse{Session: session, Current: session.SessionID == currentID})
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": response})
+}
+
+// revokeSession logs out a single device of the user
+func revokeSession(c *gin.Context) {
+	userID, exist := c.Get("userID")
+	if !exist {
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
+		return
+	}
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.Fail(c, middleware.BadRequest("Invalid session ID format"))
+		return
+	}
+	// Ensure the session belongs to the user
+	var session model.Session
+	if err := middleware.DB(c).
+		Where("session_id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID.(uuid.UUID)).
+		First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			middleware.Fail(c, middleware.NotFound("Session not found"))
+		} else {
+			middleware.Fail(c, middleware.Internal(err, "Failed to fetch session"))
+		}
+		return
+	}
+	if err := middleware.RevokeSession(c.Request.Context(), session.SessionID); err != nil {
+		logrus.WithContext(c.Request.Context()).Errorf("Failed to revoke session %s: %v", session.SessionID, err)
+		middleware.Fail(c, middleware.Internal(err, "Failed to revoke session"))
+		return
+	}
+	// Revoking the current device is same as logout
+	if currentID, ok := middleware.CurrentSessionID(c); ok && currentID == session.SessionID {
+		middleware.ClearAuthCookie(c)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// revokeOtherSessions logs out every device of the user except the one making the request
+func revokeOtherSessions(c *gin.Context) {
+	userID, exist := c.Get("userID")
+	if !exist {
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
+		return
+	}
+	currentID, ok := middleware.CurrentSessionID(c)
+	if !ok {
+		middleware.Fail(c, middleware.BadRequest("Current session not found, please login again").WithCode(middleware.CodeSessionExpired))
+		return
+	}
+	if err := middleware.RevokeAllSessions(c.Request.Context(), userID.(uuid.UUID), currentID); err != nil {
+		logrus.WithContext(c.Request.Context()).Errorf("Failed to revoke sessions of user %s: %v", userID, err)
+		middleware.Fail(c, middleware.Internal(err, "Failed to revoke sessions"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out from all other devices"})
+}
@@ -1,9 +1,10 @@
 package auth
 
 import (
-	"compass/connections"
+	"compass/middleware"
 	"compass/model"
 	"compass/workers"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -20,53 +22,43 @@ import (
 )
 
 func signupHandler(c *gin.Context) {
-	var input LoginSignupRequest
+	var input SignupRequest
 
 	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		middleware.Fail(c, middleware.InvalidInput(err))
 		return
 	}
 	//Allow only IITK emails
 	if !strings.HasSuffix(input.Email, "@iitk.ac.in") {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Please use a valid IIT Kanpur email address"})
+		middleware.Fail(c, middleware.BadRequest("Please use a valid IIT Kanpur email address"))
 		return
 	}
-
-	// FOR DEV: BYPASS RECAPTCHA
-	// ----------------------------------------------------------------------------- //
-	// Throws error if captcha verification fails
-	// registers the user in the DB only when the captcha is passed
-
-	if viper.GetString("env") == "prod" {
-		if !verifyRecaptcha(input.Token) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Failed captcha verification"})
-			return
-		}
+	if problems := checkPassword(c.Request.Context(), input.Password, input.Email); len(problems) > 0 {
+		respondPasswordProblems(c, "password", problems)
+		return
 	}
-	// ----------------------------------------------------------------------------- //
 
 	// TODO: extract out the user model generation into a single transaction
 	// Generate token and the user
 	hashPass, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating user"})
+		middleware.Fail(c, middleware.Internal(err, "Error creating user"))
 		return
 	}
 
 	//  Generating verification token
-	token := generateVerificationToken()
-	expiry := time.Now().Add(time.Duration(viper.GetInt("expiry.emailVerification")) * time.Hour).Format(time.RFC3339)
+	token, storedToken := newVerificationToken()
 	user := model.User{
 		Email:             input.Email,
 		Password:          string(hashPass),
 		IsVerified:        false,
 		Role:              model.UserRole,
-		VerificationToken: fmt.Sprintf("%s<>%s", token, expiry),
+		VerificationToken: storedToken,
 		Profile:           model.Profile{Email: input.Email, Visibility: true},
 	}
 
 	// Saving user in DB and updating in changelog
-	if err := connections.DB.Transaction(func(tx *gorm.DB) error {
+	if err := middleware.DB(c).Transaction(func(tx *gorm.DB) error {
 		// Create the User (and Profile via nested struct)
 		if err := tx.Create(&user).Error; err != nil {
 			return err // This error bubbles up to the if err != nil check below
@@ -87,29 +79,48 @@ func signupHandler(c *gin.Context) {
 		// Handle Duplicate User Error (Postgres Code 23505)
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
-			c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
+			middleware.Fail(c, middleware.Conflict("User already exists"))
 			return
 		}
 		// Handle other DB errors
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating user"})
+		middleware.Fail(c, middleware.Internal(err, "Error creating user"))
 		return
 	}
 
 	//  Add mail job to queue
-	verifyLink := fmt.Sprintf("%s/signup?token=%s&userID=%s",
-		// Dev Mode, call the anonymous function
-		func() string {
-			if viper.GetString("domain") == "" {
-				return "http://localhost:3000"
-			}
-			return fmt.Sprintf("https://%s.%s", "auth", viper.GetString("domain"))
-		}(),
-		token,
-		user.UserID)
+	if err := publishVerificationMail(c.Request.Context(), user.Email, user.UserID, token); err != nil {
+		// Log but continue
+		logrus.WithContext(c.Request.Context()).Error("Failed to enqueue mail job:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Signup successful. Please check your email to verify.",
+		"userID":  user.UserID,
+	})
+}
+
+// frontendURL is the base url of the web app, used for the links in the mails
+func frontendURL() string {
+	// Dev Mode
+	if viper.GetString("domain") == "" {
+		return "http://localhost:3000"
+	}
+	return fmt.Sprintf("https://%s.%s", "auth", viper.GetString("domain"))
+}
+
+// newVerificationToken gives the otp to mail and the value to keep in User.VerificationToken (otp<>expiry)
+func newVerificationToken() (string, string) {
+	token := generateVerificationToken()
+	expiry := time.Now().Add(time.Duration(viper.GetInt("expiry.emailVerification")) * time.Hour).Format(time.RFC3339)
+	return token, fmt.Sprintf("%s<>%s", token, expiry)
+}
+
+func publishVerificationMail(ctx context.Context, email string, userID uuid.UUID, token string) error {
+	verifyLink := fmt.Sprintf("%s/signup?token=%s&userID=%s", frontendURL(), token, userID)
 
 	job := workers.MailJob{
 		Type: "user_verification",
-		To:   input.Email,
+		To:   email,
 		Data: map[string]interface{}{
 			// To match the format in the UI, kB1-2Cd etc.
 			"token": fmt.Sprintf("%s-%s", token[:3], token[3:]),
@@ -117,13 +128,5 @@ func signupHandler(c *gin.Context) {
 		},
 	}
 	payload, _ := json.Marshal(job)
-	if err := workers.PublishJob(payload, model.MailQueue); err != nil {
-		// Log but continue
-		logrus.Error("Failed to enqueue mail job:", err)
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Signup successful. Please check your email to verify.",
-		"userID":  user.UserID,
-	})
+	return workers.PublishJob(ctx, payload, model.MailQueue)
 }
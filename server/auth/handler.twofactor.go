@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"compass/middleware"
+	"compass/model"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// Cookie set after a correct password when the account has 2FA, the login finishes at /2fa/verify
+const twoFactorChallengeCookie = "2fa_challenge"
+
+type twoFactorChallengeClaims struct {
+	UserID uuid.UUID `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
+func twoFactorThrottleKey(userID uuid.UUID) string {
+	return "2fa:" + userID.String()
+}
+
+// completeLogin is called once the first factor is verified, it either starts the session
+// or, for accounts with 2FA, leaves a short lived challenge to be answered with a code.
+func completeLogin(c *gin.Context, userID uuid.UUID) (challenged bool, err error) {
+	enabled, err := twoFactorEnabled(c.Request.Context(), userID)
+	if err != nil {
+		return false, err
+	}
+	if !enabled {
+		return false, issueSession(c, userID, false)
+	}
+	expiry := time.Duration(viper.GetInt("twoFactor.challengeExpiry")) * time.Minute
+	token, err := middleware.SignClaims(middleware.TwoFactorChallengeToken, twoFactorChallengeClaims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   twoFactorChallengeCookie,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	middleware.ClearAuthCookie(c)
+	middleware.SetStateCookie(c, twoFactorChallengeCookie, token, expiry)
+	return true, nil
+}
+
+// verifyTwoFactorHandler answers the login challenge with a totp or a recovery code
+func verifyTwoFactorHandler(c *gin.Context) {
+	var req TwoFactorVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Code == "") == (req.RecoveryCode == "") {
+		middleware.Fail(c, middleware.BadRequest("Provide either a code or a recovery code"))
+		return
+	}
+	token, err := c.Cookie(twoFactorChallengeCookie)
+	if err != nil {
+		middleware.Fail(c, middleware.Unauthorized("Login expired, please login again").WithCode(middleware.CodeSessionExpired))
+		return
+	}
+	var claims twoFactorChallengeClaims
+	if err := middleware.ParseClaims(middleware.TwoFactorChallengeToken, token, &claims); err != nil || claims.Subject != twoFactorChallengeCookie || claims.UserID == uuid.Nil {
+		middleware.ClearStateCookie(c, twoFactorChallengeCookie)
+		middleware.Fail(c, middleware.Unauthorized("Login expired, please login again").WithCode(middleware.CodeSessionExpired))
+		return
+	}
+
+	// Six digits are easy to guess, use the same backoff as the password
+	key := twoFactorThrottleKey(claims.UserID)
+	wait, err := throttleWait(c.Request.Context(), key)
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Database error"))
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		middleware.Fail(c, middleware.TooManyRequests("Too many failed attempts, please try again later"))
+		return
+	}
+
+	tf, err := getTwoFactor(c.Request.Context(), claims.UserID)
+	if err != nil || !tf.Enabled {
+		// 2FA got disabled in between, the password is already verified though, login again is simpler
+		middleware.ClearStateCookie(c, twoFactorChallengeCookie)
+		middleware.Fail(c, middleware.Unauthorized("Login expired, please login again").WithCode(middleware.CodeSessionExpired))
+		return
+	}
+	if req.Code != "" {
+		err = verifyTOTP(c.Request.Context(), tf, req.Code)
+	} else {
+		err = useRecoveryCode(c.Request.Context(), claims.UserID, req.RecoveryCode)
+	}
+	if errors.Is(err, errTOTPInvalid) {
+		locked, err := recordLoginFailure(c.Request.Context(), key, loginLimits("email"))
+		if err != nil {
+			logrus.WithContext(c.Request.Context()).Errorf("Failed to record 2fa failure for %s: %v", key, err)
+		} else if locked {
+			logLockout(c.Request.Context(), key, c.ClientIP())
+		}
+		middleware.Fail(c, middleware.Unauthorized("Invalid code"))
+		return
+	}
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Database error"))
+		return
+	}
+	resetThrottle(c.Request.Context(), key)
+
+	if err := issueSession(c, claims.UserID, true); err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to generate token"))
+		return
+	}
+	middleware.ClearStateCookie(c, twoFactorChallengeCookie)
+	c.JSON(http.StatusOK, gin.H{"message": "Login successful"})
+}
+
+// enrollTwoFactorHandler generates a new secret, it is enabled only after a code from the app is verified
+func enrollTwoFactorHandler(c *gin.Context) {
+	userID, exist := c.Get("userID")
+	if !exist {
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
+		return
+	}
+	var user model.User
+	if err := middleware.DB(c).Model(&model.User{}).Select("user_id", "email").
+		Where("user_id = ?", userID).First(&user).Error; err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to fetch user"))
+		return
+	}
+	enabled, err := twoFactorEnabled(c.Request.Context(), user.UserID)
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Database error"))
+		return
+	}
+	if enabled {
+		middleware.Fail(c, middleware.Conflict("Two factor authentication is already enabled"))
+		return
+	}
+
+	key, err := newTOTPKey(user.Email)
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to generate secret"))
+		return
+	}
+	qr, err := qrDataURL(key)
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to generate QR code"))
+		return
+	}
+	// Starting over replaces a pending secret
+	tf := model.TwoFactor{UserID: user.UserID, Secret: key.Secret()}
+	if err := middleware.DB(c).Save(&tf).Error; err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to save secret"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"secret": key.Secret(), "uri": key.URL(), "qr": qr})
+}
+
+// activateTwoFactorHandler enables 2FA with the first code from the app and hands out the recovery codes
+func activateTwoFactorHandler(c *gin.Context) {
+	userID, exist := c.Get("userID")
+	if !exist {
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
+		return
+	}
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.Fail(c, middleware.InvalidInput(err))
+		return
+	}
+	tf, err := getTwoFactor(c.Request.Context(), userID.(uuid.UUID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		middleware.Fail(c, middleware.BadRequest("Start the enrollment first"))
+		return
+	}
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Database error"))
+		return
+	}
+	if tf.Enabled {
+		middleware.Fail(c, middleware.Conflict("Two factor authentication is already enabled"))
+		return
+	}
+	step, ok := matchTOTP(tf.Secret, req.Code, 0)
+	if !ok {
+		middleware.Fail(c, middleware.BadRequest("Invalid code, check the time on your phone"))
+		return
+	}
+
+	var codes []string
+	err = middleware.DB(c).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&model.TwoFactor{}).Where("user_id = ?", tf.UserID).
+			Updates(map[string]interface{}{"enabled": true, "enabled_at": now, "last_used_step": step}).Error; err != nil {
+			return err
+		}
+		codes, err = newRecoveryCodes(tx, tf.UserID)
+		return err
+	})
+	if err != nil {
+		logrus.WithContext(c.Request.Context()).Errorf("Failed to enable 2fa for user %s: %v", tf.UserID, err)
+		middleware.Fail(c, middleware.Internal(err, "Failed to enable two factor authentication"))
+		return
+	}
+
+	// The code just verified counts as the second factor of the current login
+	if sessionID, ok := middleware.CurrentSessionID(c); ok {
+		if err := middleware.MarkSessionTwoFactor(c.Request.Context(), sessionID); err != nil {
+			logrus.WithContext(c.Request.Context()).Errorf("Failed to mark session %s as 2fa: %v", sessionID, err)
+		}
+	}
+	if token, err := middleware.GenerateAccessToken(c.Request.Context(), tf.UserID, true); err == nil {
+		middleware.SetAuthCookie(c, token)
+	}
+	// The other devices never passed the second factor, they log in again with it
+	currentID, _ := middleware.CurrentSessionID(c)
+	if err := middleware.RevokeAllSessions(c.Request.Context(), tf.UserID, currentID); err != nil {
+		logrus.WithContext(c.Request.Context()).Errorf("Failed to revoke sessions of user %s after enabling 2fa: %v", tf.UserID, err)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Two factor authentication enabled, keep the recovery codes safe, they are shown only once",
+		"recoveryCodes": codes,
+	})
+}
+
+// regenerateRecoveryCodesHandler replaces all the recovery codes, needs a login that passed 2FA
+func regenerateRecoveryCodesHandler(c *gin.Context) {
+	userID, exist := c.Get("userID")
+	if !exist {
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
+		return
+	}
+	if !c.GetBool("twoFactor") {
+		middleware.Fail(c, middleware.Forbidden("Login with two factor authentication to manage recovery codes"))
+		return
+	}
+	var codes []string
+	err := middleware.DB(c).Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = newRecoveryCodes(tx, userID.(uuid.UUID))
+		return err
+	})
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to generate recovery codes"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// disableTwoFactorHandler turns 2FA off with a current code, not allowed for roles where it is mandatory
+func disableTwoFactorHandler(c *gin.Context) {
+	userID, exist := c.Get("userID")
+	if !exist {
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
+		return
+	}
+	if twoFactorRequired(model.Role(c.GetInt("userRole"))) {
+		middleware.Fail(c, middleware.Forbidden("Two factor authentication is mandatory for your role"))
+		return
+	}
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.Fail(c, middleware.InvalidInput(err))
+		return
+	}
+	tf, err := getTwoFactor(c.Request.Context(), userID.(uuid.UUID))
+	if err != nil || !tf.Enabled {
+		middleware.Fail(c, middleware.BadRequest("Two factor authentication is not enabled"))
+		return
+	}
+	if err := verifyTOTP(c.Request.Context(), tf, req.Code); err != nil {
+		middleware.Fail(c, middleware.Unauthorized("Invalid code"))
+		return
+	}
+	err = middleware.DB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", tf.UserID).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", tf.UserID).Delete(&model.TwoFactor{}).Error
+	})
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to disable two factor authentication"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Two factor authentication disabled"})
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"bytes"
+	"compass/connections"
+	"compass/middleware"
+	"compass/model"
+	"compass/testutil"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pquerna/otp/totp"
+)
+
+// login starts a session of the user like a login on another device, it gives the refresh token
+func login(t *testing.T, userID uuid.UUID) string {
+	t.Helper()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/auth/login", nil)
+	token, err := middleware.GenerateRefreshToken(c, userID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// The sessions from before 2FA never passed it, they must not outlive its activation
+func TestActivateTwoFactorRevokesOtherSessions(t *testing.T) {
+	testutil.UseDB(t)
+	user := createVerifiedUser(t, "student@iitk.ac.in")
+	current := login(t, user.UserID)
+	login(t, user.UserID)
+
+	key, err := newTOTPKey(user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := connections.DB.Create(&model.TwoFactor{UserID: user.UserID, Secret: key.Secret()}).Error; err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.GenerateCode(key.Secret(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(middleware.Errors())
+	r.POST("/2fa/activate", func(c *gin.Context) {
+		c.Set("userID", user.UserID)
+		c.Next()
+	}, activateTwoFactorHandler)
+	body, _ := json.Marshal(TwoFactorCodeRequest{Code: code})
+	req := httptest.NewRequest(http.MethodPost, "/2fa/activate", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: current})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("activate status = %d, body %s", w.Code, w.Body)
+	}
+
+	var sessions []model.Session
+	if err := connections.DB.Find(&sessions, "user_id = ?", user.UserID).Error; err != nil {
+		t.Fatal(err)
+	}
+	// The current session is the one marked 2fa by the activation
+	kept, revoked := 0, 0
+	for _, session := range sessions {
+		switch {
+		case session.TwoFactor && session.RevokedAt == nil:
+			kept++
+		case !session.TwoFactor && session.RevokedAt != nil:
+			revoked++
+		}
+	}
+	if len(sessions) != 2 || kept != 1 || revoked != 1 {
+		t.Errorf("got %d sessions with %d kept and %d revoked, want the current one kept and the other revoked", len(sessions), kept, revoked)
+	}
+}
@@ -1,18 +1,23 @@
 package auth
 
 import (
-	"compass/connections"
 	"compass/middleware"
 	"compass/model"
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"math"
 	"math/big"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
 )
 
 
@@ -26,56 +31,108 @@ func generateVerificationToken() string {
 }
 
 func verificationHandler(c *gin.Context) {
-	var db = connections.DB
+	var db = middleware.DB(c)
 	token := c.Query("token")
 	userID, err := uuid.Parse(c.Query("userID"))
 	if token == "" || err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request"})
+		middleware.Fail(c, middleware.BadRequest("Invalid Request"))
 		return
 	}
 	var user model.User
 	if err := db.Where("user_id = ?", userID).First(&user).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User not found"})
+		middleware.Fail(c, middleware.BadRequest("User not found"))
 		return
 	}
 	tokenSplit := strings.Split(user.VerificationToken, "<>")
 	if len(tokenSplit) != 2 {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token not generated properly"})
+		middleware.Fail(c, middleware.Unauthorized("Token not generated properly"))
 		return
 	}
 	// TODO: better way to fix the formate for time.Parse
 	expiryTime, err := time.Parse(time.RFC3339, tokenSplit[1])
 	fmt.Println(tokenSplit[1], time.Now())
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token time"})
+		middleware.Fail(c, middleware.Unauthorized("Invalid token time"))
 		return
 	}
 	if time.Now().After(expiryTime) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token expired"})
+		middleware.Fail(c, middleware.Unauthorized("Token expired"))
 		return
 	}
 	if tokenSplit[0] != token {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid OTP"})
+		middleware.Fail(c, middleware.Unauthorized("Invalid OTP"))
 		return
 	}
 	user.IsVerified = true
 	user.VerificationToken = ""
-	if db.Save(&user).Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Request Failed, Please try again later"})
+	if err := db.Save(&user).Error; err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Request Failed, Please try again later"))
 		return
 	}
-	accessToken, err := middleware.GenerateAccessToken(user.UserID);
-	refreshToken, err := middleware.GenerateRefreshToken(user.UserID);
-	if err != nil {
+	// A new account can't have 2FA yet
+	if err := issueSession(c, user.UserID, false); err != nil {
 		// TODO: Redirect to login page
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token, you will need to login!"})
+		middleware.Fail(c, middleware.Internal(err, "Failed to generate token, you will need to login!"))
 		return
 	}
-	// set cookie
-	middleware.ClearAuthCookie(c) // Clear the previous cookie
-
-	// TODO: Make sure both cookies are set properly, i observed previously that only auth cookie was being set after otp verification
-	middleware.SetRefreshCookie(c, refreshToken)
-middleware.SetAuthCookie(c, accessToken)
 	c.JSON(http.StatusOK, gin.H{"message": "Email verification successful."})
 }
+
+// Same response whether the account exists, is already verified or not, so this route can't be used to find registered emails
+const resendVerificationMessage = "If an unverified account exists for this email, a new verification code has been sent to it."
+
+func resendVerificationHandler(c *gin.Context) {
+	var input ResendVerificationRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		middleware.Fail(c, middleware.InvalidInput(err))
+		return
+	}
+
+	// Cooldown between two mails and a cap per day, the mail server has its own limits.
+	// Taken before the lookup, unknown and verified emails run into the same 429.
+	wait, err := takeQuota(
+		c.Request.Context(),
+		"verify:email:"+strings.ToLower(strings.TrimSpace(input.Email)),
+		time.Duration(viper.GetInt("verification.resendCooldown"))*time.Second,
+		viper.GetInt("verification.resendDailyLimit"),
+		24*time.Hour,
+	)
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Request Failed, Please try again later"))
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		middleware.Fail(c, middleware.TooManyRequests("Please wait before requesting another code"))
+		return
+	}
+
+	var user model.User
+	if err := middleware.DB(c).Model(&model.User{}).Select("user_id", "email", "is_verified").
+		Where("email = ?", input.Email).First(&user).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logrus.WithContext(c.Request.Context()).Error("Failed to fetch user for verification resend: ", err)
+		}
+		c.JSON(http.StatusOK, gin.H{"message": resendVerificationMessage})
+		return
+	}
+	if user.IsVerified {
+		c.JSON(http.StatusOK, gin.H{"message": resendVerificationMessage})
+		return
+	}
+
+	// The previous code stops working
+	token, storedToken := newVerificationToken()
+	if err := middleware.DB(c).Model(&model.User{}).
+		Where("user_id = ?", user.UserID).
+		Update("verification_token", storedToken).Error; err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Request Failed, Please try again later"))
+		return
+	}
+	if err := publishVerificationMail(c.Request.Context(), user.Email, user.UserID, token); err != nil {
+		logrus.WithContext(c.Request.Context()).Error("Failed to enqueue verification mail:", err)
+		middleware.Fail(c, middleware.Internal(err, "Unable to send the verification code, please try again later"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": resendVerificationMessage})
+}
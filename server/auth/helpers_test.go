@@ -0,0 +1,19 @@
+package auth
+
+import (
+	"compass/connections"
+	"compass/middleware"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestMain loads the config of the server without connecting to its services, the tests set up the DB they use
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	connections.LoadConfig("../")
+	middleware.Init()
+	Init()
+	os.Exit(m.Run())
+}
@@ -0,0 +1,9 @@
+package auth
+
+// Init sets up the breached password corpus, webauthn and the student verifier from the config.
+// main calls it after middleware.Init, a missing or broken setting stops the server right at the start.
+func Init() {
+	corpus = loadBreachedCorpus()
+	relyingParty = mustRelyingParty()
+	studentVerifier = mustStudentVerifier()
+}
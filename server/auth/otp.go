@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"compass/middleware"
+	"compass/model"
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errOTPInvalid         = errors.New("invalid or expired code")
+	errOTPTooManyAttempts = errors.New("too many attempts")
+)
+
+// issueOTP generates a new 6 digit code for the given purpose and stores its hash along with the target it confirms,
+// any previous code of the user for the same purpose stops working.
+func issueOTP(ctx context.Context, userID uuid.UUID, purpose model.OTPPurpose, target string, ttl time.Duration) (string, error) {
+	code := generateVerificationToken()
+	if code == "" {
+		return "", errors.New("failed to generate code")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	otp := model.OneTimeCode{
+		UserID:    userID,
+		Purpose:   purpose,
+		CodeHash:  string(hash),
+		Target:    target,
+		ExpiresAt: time.Now().Add(ttl),
+		Attempts:  0,
+	}
+	// Upsert, as (user_id, purpose) is the primary key
+	if err := middleware.DB(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&otp).Error; err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// consumeOTP checks the code against the stored hash and returns the target it was issued for,
+// a code can be used only once and gets dropped after otp.maxAttempts wrong tries.
+func consumeOTP(ctx context.Context, userID uuid.UUID, purpose model.OTPPurpose, code string) (string, error) {
+	db := middleware.DB(ctx)
+	maxAttempts := viper.GetInt("otp.maxAttempts")
+	// Claim the attempt before comparing, parallel guesses then can't all slip in under the limit
+	claim := db.Model(&model.OneTimeCode{}).
+		Where("user_id = ? AND purpose = ? AND attempts < ?", userID, purpose, maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if claim.Error != nil {
+		return "", claim.Error
+	}
+	var otp model.OneTimeCode
+	if err := db.Where("user_id = ? AND purpose = ?", userID, purpose).First(&otp).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", errOTPInvalid
+		}
+		return "", err
+	}
+	if claim.RowsAffected == 0 {
+		db.Delete(&otp)
+		return "", errOTPTooManyAttempts
+	}
+	if time.Now().After(otp.ExpiresAt) {
+		db.Delete(&otp)
+		return "", errOTPInvalid
+	}
+	if bcrypt.CompareHashAndPassword([]byte(otp.CodeHash), []byte(code)) != nil {
+		if otp.Attempts >= maxAttempts {
+			db.Delete(&otp)
+			return "", errOTPTooManyAttempts
+		}
+		return "", errOTPInvalid
+	}
+	// Used once, only the request which removes it gets the target
+	used := db.Where("user_id = ? AND purpose = ? AND code_hash = ?", userID, purpose, otp.CodeHash).Delete(&model.OneTimeCode{})
+	if used.Error != nil {
+		return "", used.Error
+	}
+	if used.RowsAffected == 0 {
+		return "", errOTPInvalid
+	}
+	return otp.Target, nil
+}
+
+// normalizeOTP strips the separator added for readability in the mails
+func normalizeOTP(code string) string {
+	return strings.ReplaceAll(strings.TrimSpace(code), "-", "")
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"compass/middleware"
+	"compass/model"
+	"context"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// relyingParty is set up by Init
+var relyingParty *webauthn.WebAuthn
+
+// mustRelyingParty sets up webauthn from the webauthn block in config, the rpId must be the
+// domain (or a parent of it) the frontend is served on, and the origins the exact frontend urls.
+func mustRelyingParty() *webauthn.WebAuthn {
+	rp, err := webauthn.New(&webauthn.Config{
+		RPID:          viper.GetString("webauthn.rpId"),
+		RPDisplayName: viper.GetString("webauthn.rpName"),
+		RPOrigins:     viper.GetStringSlice("webauthn.origins"),
+		// We only need the public key, no attestation so any authenticator (including software ones) works
+		AttestationPreference: protocol.PreferNoAttestation,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.UserVerificationRequirement(viper.GetString("webauthn.userVerification")),
+		},
+	})
+	if err != nil {
+		logrus.Fatal("Failed to set up webauthn: ", err)
+	}
+	return rp
+}
+
+// passkeyUser is the webauthn.User of a model.User, the user handle is the UserID
+type passkeyUser struct {
+	user     model.User
+	passkeys []model.Passkey
+}
+
+func (u passkeyUser) WebAuthnID() []byte {
+	return u.user.UserID[:]
+}
+
+func (u passkeyUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u passkeyUser) WebAuthnDisplayName() string {
+	return u.user.Email
+}
+
+func (u passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.passkeys))
+	for _, passkey := range u.passkeys {
+		credentials = append(credentials, passkey.Credential)
+	}
+	return credentials
+}
+
+// loadPasskeyUser fetches the user with their registered passkeys
+func loadPasskeyUser(ctx context.Context, userID uuid.UUID) (passkeyUser, error) {
+	var u passkeyUser
+	if err := middleware.DB(ctx).Model(&model.User{}).Select("user_id", "email", "password", "is_verified").
+		Where("user_id = ?", userID).First(&u.user).Error; err != nil {
+		return u, err
+	}
+	err := middleware.DB(ctx).Where("user_id = ?", userID).Find(&u.passkeys).Error
+	return u, err
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"bufio"
+	"compass/middleware"
+	"compass/model"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// checkPassword applies the password policy of the password block in config,
+// it returns the problems found in a form that can be shown under the field, none when the password is fine.
+func checkPassword(ctx context.Context, password string, email string) []string {
+	var problems []string
+	minLength := viper.GetInt("password.minLength")
+	maxLength := viper.GetInt("password.maxLength")
+	if len([]rune(password)) < minLength {
+		problems = append(problems, fmt.Sprintf("Must be at least %d characters", minLength))
+	}
+	// bcrypt ignores everything after 72 bytes
+	if maxLength > 0 && len(password) > maxLength {
+		problems = append(problems, fmt.Sprintf("Must be at most %d characters", maxLength))
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			classes++
+		}
+	}
+	if minClasses := viper.GetInt("password.minClasses"); classes < minClasses {
+		problems = append(problems, fmt.Sprintf("Must use at least %d of lowercase letters, uppercase letters, digits and symbols", minClasses))
+	}
+
+	// The part before @ is the roll number or the username, the first thing anyone tries
+	if local, _, _ := strings.Cut(strings.ToLower(email), "@"); len(local) >= 3 && strings.Contains(strings.ToLower(password), local) {
+		problems = append(problems, "Must not contain your email")
+	}
+
+	if len(problems) == 0 {
+		breached, err := passwordBreached(password)
+		if err != nil {
+			// The corpus is a second line of defence, a broken file should not block every signup
+			logrus.WithContext(ctx).Error("Failed to check the breached password corpus: ", err)
+		} else if breached {
+			problems = append(problems, "This password has appeared in a data breach, please choose another")
+		}
+	}
+	return problems
+}
+
+// breachedRanges is the number of range files of a full download, one per 5 hex chars prefix
+const breachedRanges = 1 << 20
+
+// breachedCorpus is the corpus checked at startup, a partial one (like the dev stub in auth/breached) only
+// knows a few prefixes and a missing range file is then expected, in a full download it means a broken corpus.
+type breachedCorpus struct {
+	dir      string
+	complete bool
+}
+
+// corpus is checked by Init
+var corpus breachedCorpus
+
+// loadBreachedCorpus checks password.breachedCorpus, production needs the full download
+func loadBreachedCorpus() breachedCorpus {
+	dir := viper.GetString("password.breachedCorpus")
+	prod := viper.GetString("env") == "prod"
+	if dir == "" {
+		if prod {
+			logrus.Fatal("password.breachedCorpus must point to the full breached password download in production")
+		}
+		return breachedCorpus{}
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		logrus.Fatal("Failed to open the breached password corpus: ", err)
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		logrus.Fatal("Failed to read the breached password corpus: ", err)
+	}
+	ranges := 0
+	for _, name := range names {
+		if filepath.Ext(name) == ".txt" {
+			ranges++
+		}
+	}
+	if ranges < breachedRanges {
+		if prod {
+			logrus.Fatalf("Breached password corpus %s has %d of the %d range files, production needs the full download", dir, ranges, breachedRanges)
+		}
+		logrus.Warnf("Breached password corpus %s is partial (%d range files, like the dev stub), most breached passwords will pass", dir, ranges)
+	}
+	return breachedCorpus{dir: dir, complete: ranges >= breachedRanges}
+}
+
+// passwordBreached looks the password up in the local corpus, laid out like the k-anonymity range api of
+// haveibeenpwned: a file per first 5 hex chars of the SHA-1, holding "SUFFIX:COUNT" lines of the rest.
+// Only the range file of the prefix is read, the corpus can be the full dump without loading it in memory.
+func passwordBreached(password string) (bool, error) {
+	if corpus.dir == "" {
+		return false, nil
+	}
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(corpus.dir, prefix+".txt"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !corpus.complete {
+			return false, nil
+		}
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		candidate, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(candidate, suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// respondPasswordProblems is the field level error for the frontend to show under the password input
+func respondPasswordProblems(c *gin.Context, field string, problems []string) {
+	middleware.Fail(c, middleware.BadRequest("Password does not meet the requirements").
+		WithCode(middleware.CodeWeakPassword).
+		WithDetails(gin.H{"fields": gin.H{field: problems}}))
+}
+
+// confirmPassword asks the current password again before a sensitive change, wrong ones count against
+// the same throttle as the login. It writes the error response and returns false when the change must stop.
+func confirmPassword(c *gin.Context, user model.User, password string) bool {
+	// Accounts created through SSO have no password to ask, a recent login stands in for it
+	if user.Password == "" {
+		return confirmFreshLogin(c, user)
+	}
+	if password == "" {
+		middleware.Fail(c, middleware.BadRequest("Please enter your current password"))
+		return false
+	}
+	emailKey := emailThrottleKey(user.Email)
+	wait, err := throttleWait(c.Request.Context(), emailKey)
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Database error"))
+		return false
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		middleware.Fail(c, middleware.TooManyRequests("Too many failed attempts, please try again later"))
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		if locked, err := recordLoginFailure(c.Request.Context(), emailKey, loginLimits("email")); err != nil {
+			logrus.WithContext(c.Request.Context()).Errorf("Failed to record login failure for %s: %v", emailKey, err)
+		} else if locked {
+			logLockout(c.Request.Context(), emailKey, c.ClientIP())
+		}
+		middleware.Fail(c, middleware.Unauthorized("Invalid password"))
+		return false
+	}
+	return true
+}
+
+// confirmFreshLogin accepts the change when the session of the request was started within password.freshLogin,
+// else the user is sent to login again (through the IdP) and retries.
+func confirmFreshLogin(c *gin.Context, user model.User) bool {
+	maxAge := time.Duration(viper.GetInt("password.freshLogin")) * time.Minute
+	var fresh int64
+	if sessionID, ok := middleware.CurrentSessionID(c); ok {
+		if err := middleware.DB(c).Model(&model.Session{}).
+			Where("session_id = ? AND user_id = ? AND revoked_at IS NULL AND created_at > ?", sessionID, user.UserID, time.Now().Add(-maxAge)).
+			Count(&fresh).Error; err != nil {
+			middleware.Fail(c, middleware.Internal(err, "Database error"))
+			return false
+		}
+	}
+	if fresh == 0 {
+		middleware.Fail(c, middleware.Unauthorized("Please login again to confirm this change").WithCode(middleware.CodeReauthRequired))
+		return false
+	}
+	return true
+}
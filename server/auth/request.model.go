@@ -1,24 +1,70 @@
 package auth
 
-type LoginSignupRequest struct {
+import "github.com/google/uuid"
+
+type LoginRequest struct {
 	Email    string `form:"email" binding:"required,email"`
 	Password string `form:"password" binding:"required,min=8"`
-	// FOR DEV: BYPASS
+}
+
+// The password is checked against the policy in the handler, to give field level errors
+type SignupRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Token    string `json:"otp" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type MagicLinkVerifyRequest struct {
 	Token string `json:"token" binding:"required"`
 }
 
-type UpdatePasswordRequest struct {
-	NewPassword string `json:"password"`
+type PasswordlessRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+type ChangeEmailRequest struct {
+	NewEmail string `json:"newEmail" binding:"required,email"`
+	Password string `json:"password"` // empty for accounts without a password (SSO), they need a fresh login
+}
+
+type PasskeyRegistrationRequest struct {
+	Password string `json:"password"` // empty for accounts without a password (SSO), they need a fresh login
+}
+
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"otp" binding:"required"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
 }
 
-type RecaptchaResponse struct {
-	// Output json struct from of https://www.google.com/recaptcha/api/siteverify
-	Success     bool     `json:"success"`      // whether this request was a valid reCAPTCHA token for your site
-	Score       float64  `json:"score"`        // the score for this request (0.0 - 1.0)
-	Action      string   `json:"action"`       // the action name for this request (important to verify)
-	ChallengeTS string   `json:"challenge_ts"` // timestamp of the challenge load (ISO format yyyy-MM-dd'T'HH:mm:ssZZ)
-	Hostname    string   `json:"hostname"`     // the hostname of the site where the reCAPTCHA was solved
-	ErrorCodes  []string `json:"error-codes"`  // optional
+// TwoFactorVerifyRequest answers the login challenge, exactly one of the two is expected
+type TwoFactorVerifyRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+type UpdatePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"` // empty for accounts without a password (SSO), they need a fresh login
+	NewPassword     string `json:"password" binding:"required"`
 }
 
 type ProfileUpdateRequest struct {
@@ -32,15 +78,6 @@ type ProfileUpdateRequest struct {
 	HomeTown   string `json:"homeTown"`
 }
 
-type CCResponse struct {
-	RollNumber *string `json:"rollNumber"`
-	Name       *string `json:"name"`
-	Email      *string `json:"email"`
-	Status     *string `json:"status"`
-	Timestamp  *string `json:"timestamp"`
-	Message    *string `json:"message"`
-}
-
 type StudentDetails struct {
 	RollNo     string `json:"roll_no"`
 	Name       string `json:"name"`
@@ -50,4 +87,10 @@ type StudentDetails struct {
 	HostelInfo string `json:"hostel_info"`
 	Username   string `json:"username"`
 	Location   string `json:"location"`
-}
\ No newline at end of file
+}
+
+type ImpersonateRequest struct {
+	UserID   uuid.UUID `json:"userId" binding:"required"`
+	Reason   string    `json:"reason" binding:"required,max=500"` // kept in the logs
+	Elevated bool      `json:"elevated"`                          // allow writes as the user
+}
@@ -4,18 +4,30 @@ package auth
 
 import (
 	"compass/middleware"
+	"compass/model"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
 func Router(r *gin.Engine) {
+	// Public keys to verify our tokens, for the other services
+	r.GET("/.well-known/jwks.json", middleware.JWKSHandler)
 	auth := r.Group("/api/auth")
 	{
-		auth.POST("/login", loginHandler)
-		auth.POST("/signup", signupHandler)
-		auth.GET("/logout", logoutHandler)
+		auth.POST("/login", middleware.Captcha("login"), loginHandler)
+		auth.POST("/signup", middleware.Captcha("signup"), signupHandler)
+		auth.POST("/logout", logoutHandler)
+		auth.GET("/csrf", middleware.CSRFTokenHandler) // token for the X-CSRF-Token header, also set as the csrf_token cookie
 		auth.GET("/verify", verificationHandler)
+		auth.POST("/verify/resend", middleware.Captcha("resend_verification"), resendVerificationHandler)
+		auth.POST("/forgot", middleware.Captcha("forgot_password"), forgotPasswordHandler) // mails a reset otp
+		auth.POST("/reset", resetPasswordHandler)
+		auth.POST("/magic", middleware.Captcha("magic_link"), magicLinkHandler) // mails a login link, for users who opted in
+		auth.POST("/magic/verify", magicLinkVerifyHandler)
+		auth.POST("/magic/settings", middleware.UserAuthenticator, middleware.NoImpersonation, passwordlessHandler)
+		auth.GET("/oidc/login", oidcLoginHandler) // institute sso, browser navigations not api calls
+		auth.GET("/oidc/callback", oidcCallbackHandler)
 		// Middleware will handel not login state
 		auth.GET("/me", middleware.UserAuthenticator, func(c *gin.Context) {
 			val, exists := c.Get("visibility")
@@ -27,22 +39,71 @@ func Router(r *gin.Engine) {
 				isVisible = false 
 			}
 
+			// the frontend shows a banner while an admin views as the user
+			_, impersonating := c.Get("impersonator")
+
 			if isVisible {
 				// 200: logged in + visible
-				c.JSON(http.StatusOK, gin.H{"success": true})
+				c.JSON(http.StatusOK, gin.H{"success": true, "impersonating": impersonating})
 			} else {
 				// 202: logged in + hidden 
-				c.JSON(http.StatusAccepted, gin.H{"success": true, "status": "hidden"})
+				c.JSON(http.StatusAccepted, gin.H{"success": true, "status": "hidden", "impersonating": impersonating})
 			}
 		})
 	}
+	// Devices the user is logged in on
+	sessions := r.Group("/api/auth/sessions")
+	{
+		sessions.Use(middleware.UserAuthenticator, middleware.NoImpersonation)
+		sessions.GET("", listSessions)
+		sessions.DELETE("", revokeOtherSessions) // all except the current one
+		sessions.DELETE("/:id", revokeSession)
+	}
+	// Change of the login email, confirmed with a code sent to the new address
+	email := r.Group("/api/auth/email")
+	{
+		email.Use(middleware.UserAuthenticator, middleware.NoImpersonation)
+		email.POST("/change", changeEmailHandler)
+		email.POST("/confirm", confirmEmailChangeHandler)
+	}
+	// TOTP two factor authentication
+	auth.POST("/2fa/verify", verifyTwoFactorHandler) // second step of the login, uses the challenge cookie
+	twoFactor := r.Group("/api/auth/2fa")
+	{
+		twoFactor.Use(middleware.UserAuthenticator, middleware.NoImpersonation)
+		twoFactor.POST("/enroll", enrollTwoFactorHandler)
+		twoFactor.POST("/activate", activateTwoFactorHandler)
+		twoFactor.POST("/recovery", regenerateRecoveryCodesHandler)
+		twoFactor.DELETE("", disableTwoFactorHandler)
+	}
+	// Admins viewing the app as a user to debug their reports
+	impersonate := r.Group("/api/auth/impersonate")
+	{
+		impersonate.POST("", middleware.UserAuthenticator, middleware.RequirePermission(model.UserImpersonatePermission), startImpersonationHandler)
+		impersonate.DELETE("", stopImpersonationHandler) // reads the cookie itself, a read only session must be able to stop
+	}
+	// Passkeys (WebAuthn), the options returned are passed as is to navigator.credentials.create/get
+	// and the finish endpoints take the response of the browser as the body
+	auth.POST("/passkeys/login/begin", beginPasskeyLogin)
+	auth.POST("/passkeys/login/finish", finishPasskeyLogin)
+	passkeys := r.Group("/api/auth/passkeys")
+	{
+		passkeys.Use(middleware.UserAuthenticator, middleware.NoImpersonation)
+		passkeys.GET("", listPasskeys)
+		passkeys.POST("/register/begin", beginPasskeyRegistration)
+		passkeys.POST("/register/finish", finishPasskeyRegistration) // ?name= to label it
+		passkeys.DELETE("/:id", deletePasskey)
+	}
 	profile := r.Group("/api/profile")
 	{
 		profile.Use(middleware.UserAuthenticator)
 		profile.GET("", getProfileHandler)
 		profile.POST("", updateProfile)
 		profile.POST("/pfp", UploadProfileImage)
+		profile.POST("/password", middleware.NoImpersonation, updatePassword)
 		profile.GET("/oa", autoC)
+		profile.POST("/export", middleware.NoImpersonation, requestExportHandler) // download my data, the link is mailed
+		profile.GET("/export/:id", middleware.NoImpersonation, downloadExportHandler)
 	}
 
 }
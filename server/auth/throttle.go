@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"compass/middleware"
+	"compass/model"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// throttleLimits for a kind of key, read from the login block in config
+type throttleLimits struct {
+	FreeAttempts int // failures allowed before backoff kicks in
+	LockoutAfter int // failures after which the key is locked out
+}
+
+func loginLimits(kind string) throttleLimits {
+	return throttleLimits{
+		FreeAttempts: viper.GetInt(fmt.Sprintf("login.%s.freeAttempts", kind)),
+		LockoutAfter: viper.GetInt(fmt.Sprintf("login.%s.lockoutAfter", kind)),
+	}
+}
+
+func emailThrottleKey(email string) string {
+	return "login:email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(ip string) string {
+	return "login:ip:" + ip
+}
+
+// throttleWait tells how long the key has to wait before it may try again, zero if it is free
+func throttleWait(ctx context.Context, key string) (time.Duration, error) {
+	var throttle model.Throttle
+	if err := middleware.DB(ctx).Where("key = ?", key).First(&throttle).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if throttle.BlockedUntil == nil {
+		return 0, nil
+	}
+	if wait := time.Until(*throttle.BlockedUntil); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// recordLoginFailure counts a failed attempt for the key and blocks it with exponential backoff,
+// it returns true when the key just got locked out.
+func recordLoginFailure(ctx context.Context, key string, limits throttleLimits) (bool, error) {
+	window := time.Duration(viper.GetInt("login.window")) * time.Minute
+	lockout := time.Duration(viper.GetInt("login.lockout")) * time.Minute
+	backoffBase := time.Duration(viper.GetInt("login.backoffBase")) * time.Second
+	locked := false
+
+	err := middleware.DB(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		// Create the row if missing, then lock it so parallel failures are all counted
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&model.Throttle{Key: key, WindowStart: now, LastAt: now}).Error; err != nil {
+			return err
+		}
+		var throttle model.Throttle
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&throttle).Error; err != nil {
+			return err
+		}
+		// Old failures are forgiven
+		if now.Sub(throttle.WindowStart) > window {
+			throttle.Count = 0
+			throttle.WindowStart = now
+		}
+		throttle.Count++
+		throttle.LastAt = now
+
+		switch {
+		case throttle.Count >= limits.LockoutAfter:
+			until := now.Add(lockout)
+			throttle.BlockedUntil = &until
+			// Start afresh once the lockout is over
+			throttle.Count = 0
+			throttle.WindowStart = until
+			locked = true
+		case throttle.Count > limits.FreeAttempts:
+			// 1x, 2x, 4x ... of the base, never more than the lockout itself
+			exp := float64(throttle.Count - limits.FreeAttempts - 1)
+			backoff := time.Duration(math.Min(float64(backoffBase)*math.Pow(2, exp), float64(lockout)))
+			until := now.Add(backoff)
+			throttle.BlockedUntil = &until
+		}
+		return tx.Save(&throttle).Error
+	})
+	return locked, err
+}
+
+// takeQuota allows an action (like a mail) for the key at most limit times per window,
+// with at least cooldown between two of them. It returns how long to wait when not allowed.
+func takeQuota(ctx context.Context, key string, cooldown time.Duration, limit int, window time.Duration) (time.Duration, error) {
+	var wait time.Duration
+	err := middleware.DB(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&model.Throttle{Key: key, WindowStart: now}).Error; err != nil {
+			return err
+		}
+		var throttle model.Throttle
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&throttle).Error; err != nil {
+			return err
+		}
+		if now.Sub(throttle.WindowStart) > window {
+			throttle.Count = 0
+			throttle.WindowStart = now
+		}
+		if throttle.Count >= limit {
+			wait = throttle.WindowStart.Add(window).Sub(now)
+			return nil
+		}
+		if throttle.Count > 0 && now.Sub(throttle.LastAt) < cooldown {
+			wait = throttle.LastAt.Add(cooldown).Sub(now)
+			return nil
+		}
+		throttle.Count++
+		throttle.LastAt = now
+		return tx.Save(&throttle).Error
+	})
+	return wait, err
+}
+
+// resetThrottle forgets the failures of the key after a successful login
+func resetThrottle(ctx context.Context, key string) {
+	if err := middleware.DB(ctx).Where("key = ?", key).Delete(&model.Throttle{}).Error; err != nil {
+		logrus.WithContext(ctx).Errorf("Failed to reset throttle %s: %v", key, err)
+	}
+}
+
+// logLockout records the lockout in the admin logs, repeated lockouts of an account are worth a look
+func logLockout(ctx context.Context, key string, ip string) {
+	entry := model.NewLog(
+		"Login lockout",
+		fmt.Sprintf("Too many failed login attempts for %s (last attempt from ip %s), locked for %d minutes", key, ip, viper.GetInt("login.lockout")),
+		model.BotActor,
+	)
+	if err := middleware.DB(ctx).Create(&entry).Error; err != nil {
+		logrus.WithContext(ctx).Errorf("Failed to write lockout log for %s: %v", key, err)
+	}
+	logrus.WithContext(ctx).Warnf("Login lockout for %s from ip %s", key, ip)
+}
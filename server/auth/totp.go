@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"bytes"
+	"compass/middleware"
+	"compass/model"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"image/png"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Standard authenticator app parameters, most apps ignore anything else
+const (
+	totpPeriod = 30
+	totpSkew   = 1 // steps accepted on either side, for clock drift of the phone
+	totpDigits = otp.DigitsSix
+
+	recoveryCodeCount = 10
+)
+
+var errTOTPInvalid = errors.New("invalid two factor code")
+
+// newTOTPKey generates a fresh secret for the user, the key holds the provisioning uri for the QR
+func newTOTPKey(email string) (*otp.Key, error) {
+	return totp.Generate(totp.GenerateOpts{
+		Issuer:      viper.GetString("twoFactor.issuer"),
+		AccountName: email,
+		Period:      totpPeriod,
+		Digits:      totpDigits,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+}
+
+// qrDataURL renders the provisioning uri as a png, ready to be used as an img src
+func qrDataURL(key *otp.Key) (string, error) {
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// matchTOTP finds the time step the code belongs to, only steps after lastStep are accepted
+// so a code seen once (shoulder surfing, phishing proxy) can't be used again.
+func matchTOTP(secret string, code string, lastStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits.Length() {
+		return 0, false
+	}
+	now := time.Now().Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    totpDigits,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err == nil && expected == code {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// verifyTOTP checks the code of an enabled enrollment and records its step,
+// the conditional update makes sure parallel requests can't both use the same code.
+func verifyTOTP(ctx context.Context, tf model.TwoFactor, code string) error {
+	step, ok := matchTOTP(tf.Secret, code, tf.LastUsedStep)
+	if !ok {
+		return errTOTPInvalid
+	}
+	result := middleware.DB(ctx).Model(&model.TwoFactor{}).
+		Where("user_id = ? AND last_used_step < ?", tf.UserID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errTOTPInvalid
+	}
+	return nil
+}
+
+// getTwoFactor fetches the enrollment of the user, gorm.ErrRecordNotFound if there is none
+func getTwoFactor(ctx context.Context, userID uuid.UUID) (model.TwoFactor, error) {
+	var tf model.TwoFactor
+	err := middleware.DB(ctx).Where("user_id = ?", userID).First(&tf).Error
+	return tf, err
+}
+
+// twoFactorEnabled tells if the login of the user needs the second factor
+func twoFactorEnabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	tf, err := getTwoFactor(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return tf.Enabled, nil
+}
+
+// twoFactorRequired tells if the role can't go without 2FA
+func twoFactorRequired(role model.Role) bool {
+	return viper.GetBool("twoFactor.requiredForAdmins") && role >= model.AdminRole
+}
+
+// newRecoveryCodes replaces the recovery codes of the user, the plain codes are returned to be shown once
+func newRecoveryCodes(tx *gorm.DB, userID uuid.UUID) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]model.RecoveryCode, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.EncodeToString(b)) // 8 chars
+		hash, err := bcrypt.GenerateFromPassword([]byte(raw), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, raw[:4]+"-"+raw[4:])
+		records = append(records, model.RecoveryCode{UserID: userID, CodeHash: string(hash)})
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// useRecoveryCode marks a matching unused code as used
+func useRecoveryCode(ctx context.Context, userID uuid.UUID, code string) error {
+	code = strings.ToLower(normalizeOTP(code))
+	var records []model.RecoveryCode
+	if err := middleware.DB(ctx).Where("user_id = ? AND used_at IS NULL", userID).Find(&records).Error; err != nil {
+		return err
+	}
+	for _, record := range records {
+		if bcrypt.CompareHashAndPassword([]byte(record.CodeHash), []byte(code)) != nil {
+			continue
+		}
+		result := middleware.DB(ctx).Model(&model.RecoveryCode{}).
+			Where("id = ? AND used_at IS NULL", record.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errTOTPInvalid
+		}
+		return nil
+	}
+	return errTOTPInvalid
+}
@@ -7,13 +7,20 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 func assetServer() *http.Server {
 	PORT := viper.GetString("ports.assets")
 	r := gin.New()
+	trustProxies(r)
+	r.Use(otelgin.Middleware("assets"))
+	r.Use(middleware.RequestID())
+	r.Use(middleware.AccessLog())
+	r.Use(middleware.Metrics("assets"))
+	r.Use(middleware.Errors())
 	r.Use(middleware.CORS())
-	r.Use(gin.Logger())
+	r.Use(middleware.CSRF())
 
 	assets.Router(r)
 
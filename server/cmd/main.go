@@ -1,12 +1,16 @@
 package main
 
 import (
-	_ "compass/connections" // is a blank import and it runs the init() functions in the package
+	"compass/auth"
+	"compass/connections"
+	"compass/middleware"
 	"compass/workers"
+	"context"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -15,7 +19,20 @@ const (
 	writeTimeout = 10 * time.Second // Maximum duration before timing out when writing the response to the client, Prevents the server from being stuck forever while trying to send data to a slow or unresponsive client.
 )
 
+// trustProxies limits X-Forwarded-For to the proxies in trustedProxies, from anyone else the header
+// is ignored and the client ip (used by the rate limits and the login throttle) is the address of the connection.
+func trustProxies(r *gin.Engine) {
+	if err := r.SetTrustedProxies(viper.GetStringSlice("trustedProxies")); err != nil {
+		logrus.Fatal("Invalid trustedProxies: ", err)
+	}
+}
+
 func main() {
+	// Config and the services first, the packages below set themselves up from them
+	connections.Init()
+	middleware.Init()
+	auth.Init()
+
 	// Create an error group to handle errors together
 	var g errgroup.Group
 
@@ -33,12 +50,18 @@ func main() {
 	g.Go(func() error {
 		return workers.CleanupWorker()
 	})
+	g.Go(func() error {
+		return workers.ExportWorker()
+	})
 	g.Go(func() error { return assetServer().ListenAndServe() })
 	g.Go(func() error { return authServer().ListenAndServe() })
 	g.Go(func() error { return mapsServer().ListenAndServe() })
 	g.Go(func() error { return searchServer().ListenAndServe() })
+	g.Go(func() error { return metricsServer().ListenAndServe() })
 	logrus.Info("Main server is Starting...")
 	if err := g.Wait(); err != nil {
+		// Fatal exits right away, the spans still in the batch go out first
+		connections.ShutdownTracing(context.Background())
 		logrus.Fatal("Some service failed with error: ", err)
 	}
 
@@ -4,6 +4,7 @@ package main
 import (
 	"net/http"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"github.com/spf13/viper"
 	"compass/middleware"
 	"compass/maps"
@@ -12,8 +13,14 @@ import (
 func mapsServer() *http.Server {
 	PORT := viper.GetString("ports.maps")
 	r := gin.New()
+	trustProxies(r)
+	r.Use(otelgin.Middleware("maps"))
+	r.Use(middleware.RequestID())
+	r.Use(middleware.AccessLog())
+	r.Use(middleware.Metrics("maps"))
+	r.Use(middleware.Errors())
 	r.Use(middleware.CORS())
-	r.Use(gin.Logger())
+	r.Use(middleware.CSRF())
 
 	maps.Router(r)
 
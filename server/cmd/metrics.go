@@ -0,0 +1,25 @@
+// File for the set up of the metrics server, scraped by prometheus
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/viper"
+)
+
+// metricsServer serves /metrics on its own port, so it is never reachable through the public servers
+func metricsServer() *http.Server {
+	PORT := viper.GetString("ports.metrics")
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	server := &http.Server{
+		Addr:         ":" + PORT,
+		Handler:      mux,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+	}
+
+	return server
+}
@@ -0,0 +1,53 @@
+// Mock of the OA verification api, answers from the same fixture file as the fixture verifier.
+// Run: go run ./cmd/mockoa -fixture student/fixtures.json
+// then set oa.provider: http and oa.url: http://localhost:8091/verify
+package main
+
+import (
+	"compass/student"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	addr := flag.String("addr", ":8091", "listen address")
+	fixturePath := flag.String("fixture", "student/fixtures.json", "students to answer with")
+	key := flag.String("key", "", "x-api-key expected from the caller, empty accepts any")
+	flag.Parse()
+
+	fixture, err := student.LoadFixture(*fixturePath)
+	if err != nil {
+		logrus.Fatal("Failed to load fixture: ", err)
+	}
+
+	http.HandleFunc("/verify", func(w http.ResponseWriter, r *http.Request) {
+		if *key != "" && r.Header.Get("x-api-key") != *key {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		parts := strings.Split(r.URL.Query().Get("paramkey"), ":")
+		if len(parts) != 4 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		query := student.Query{RollNo: parts[0], Course: parts[1], Dept: parts[2], Email: parts[3]}
+
+		status, message := "false", "Student details not found"
+		now := time.Now().Format(time.RFC3339)
+		resp := student.OAResponse{RollNumber: &query.RollNo, Email: &query.Email, Status: &status, Timestamp: &now, Message: &message}
+		if s, ok := fixture.Lookup(query); ok {
+			status, message = "true", "Verified"
+			resp.Name = &s.Name
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+
+	logrus.Info("Mock OA listening on ", *addr)
+	logrus.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -6,14 +6,21 @@ import (
 	"compass/search"
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"net/http"
 )
 
 func searchServer() *http.Server {
 	PORT := viper.GetString("ports.search")
 	r := gin.New()
+	trustProxies(r)
+	r.Use(otelgin.Middleware("search"))
+	r.Use(middleware.RequestID())
+	r.Use(middleware.AccessLog())
+	r.Use(middleware.Metrics("search"))
+	r.Use(middleware.Errors())
 	r.Use(middleware.CORS())
-	r.Use(gin.Logger())
+	r.Use(middleware.CSRF())
 
 	search.Router(r)
 
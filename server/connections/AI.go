@@ -1,9 +1,12 @@
 package connections
 
 import (
+	"net/http"
+
 	"github.com/openai/openai-go/v2"
 	"github.com/openai/openai-go/v2/option"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 var AI openai.Client
@@ -11,5 +14,6 @@ var AI openai.Client
 func aiConnection() {
 	AI = openai.NewClient(
 		option.WithAPIKey(viper.GetString("openai.moderation")),
+		option.WithHTTPClient(&http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}),
 	)
 }
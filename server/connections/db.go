@@ -9,6 +9,7 @@ import (
 	"github.com/spf13/viper"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/plugin/opentelemetry/tracing"
 )
 
 var DB *gorm.DB
@@ -28,6 +29,11 @@ func dbConnection() {
 		logrus.Fatal("Failed to connect to database: ", err)
 	}
 
+	// A span per query, the values are left out of the statement as they may be emails or tokens
+	if err := database.Use(tracing.NewPlugin(tracing.WithoutQueryVariables(), tracing.WithoutMetrics())); err != nil {
+		logrus.Fatal("Failed to set up database tracing: ", err)
+	}
+
 	DB = database
 
 	models := []interface{}{
@@ -39,6 +45,18 @@ func dbConnection() {
 		&model.Image{},
 		&model.Profile{},
 		&model.ChangeLog{},
+		&model.OneTimeCode{},
+		&model.Session{},
+		&model.RefreshToken{},
+		&model.Throttle{},
+		&model.TwoFactor{},
+		&model.RecoveryCode{},
+		&model.PermissionDef{},
+		&model.RolePermission{},
+		&model.APIKey{},
+		&model.DataExport{},
+		&model.Passkey{},
+		&model.Impersonation{},
 	}
 
 	if err := DB.AutoMigrate(models...); err != nil {
@@ -46,5 +64,6 @@ func dbConnection() {
 	}
 	DB.Exec("CREATE EXTENSION IF NOT EXISTS pgcrypto")
 	DB.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm")
+	seedPermissions()
 	logrus.Info("Connected to database")
 }
@@ -1,15 +1,26 @@
 // Central place to call all other config inits (viper, logging etc.)
 package connections
 
-func init() {
-	// Initialize Viper configuration
-	viperConfig()
-	// Initialize logging
-	logrusConfig()
+// Init loads the config from the working directory and connects to the services, main calls it before anything else
+func Init() {
+	LoadConfig("./")
+	// Set up tracing, before the clients below get instrumented
+	tracingConfig()
 	// Initialize RabbitMq connection
 	initRabbitMQ()
+	// Redis connection, when configured
+	redisConnection()
 	// Database connection
 	dbConnection()
 	// Connect to moderator ai client
 	aiConnection()
 }
+
+// LoadConfig reads config.yaml (and secret.yml) from dir and sets up logging, without connecting to any service.
+// Tests call it with the server directory and bring up what they use themselves, like DB on sqlite.
+func LoadConfig(dir string) {
+	// Initialize Viper configuration
+	viperConfig(dir)
+	// Initialize logging
+	logrusConfig()
+}
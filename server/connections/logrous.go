@@ -2,24 +2,77 @@
 package connections
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 	"runtime"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func logrusConfig() {
-	logrus.SetLevel(logrus.DebugLevel) // sets the minimum log level for Logrus.
+	// sets the minimum log level for Logrus, from log.level (debug, info, warn ...)
+	level, err := logrus.ParseLevel(viper.GetString("log.level"))
+	if err != nil {
+		level = logrus.DebugLevel
+	}
+	logrus.SetLevel(level)
 	// SetReportCaller enables automatic inclusion of the calling function's file name and line number, SetFormatter is used to customize the log format
-	logrus.SetFormatter(&logrus.TextFormatter{
-		TimestampFormat: time.DateTime, // "2006-01-02 15:04:05"
-		FullTimestamp:   true,
-		CallerPrettyfier: func(f *runtime.Frame) (string, string) {
-			filename := filepath.Base(f.File) // Just the filename
-			return "", fmt.Sprintf(" %s:%d", filename, f.Line)
-		},
-	})
+	callerPrettyfier := func(f *runtime.Frame) (string, string) {
+		filename := filepath.Base(f.File) // Just the filename
+		return "", fmt.Sprintf(" %s:%d", filename, f.Line)
+	}
+	if viper.GetString("log.format") == "json" {
+		// One object per line for the log collectors, the fields (request_id etc.) become keys
+		logrus.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat: time.RFC3339Nano,
+			CallerPrettyfier: func(f *runtime.Frame) (string, string) {
+				return "", fmt.Sprintf("%s:%d", filepath.Base(f.File), f.Line)
+			},
+		})
+	} else {
+		logrus.SetFormatter(&logrus.TextFormatter{
+			TimestampFormat:  time.DateTime, // "2006-01-02 15:04:05"
+			FullTimestamp:    true,
+			CallerPrettyfier: callerPrettyfier,
+		})
+	}
 	logrus.SetReportCaller(true)
+	logrus.AddHook(requestIDHook{})
+}
+
+type requestIDKey struct{}
+
+// WithRequestID tags the context with the id of the request (or queue job) it serves
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID is the id tagged with WithRequestID, empty when there is none
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDHook adds the request_id (and trace_id when traced) fields to the entries logged with logrus.WithContext(ctx)
+type requestIDHook struct{}
+
+func (requestIDHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (requestIDHook) Fire(entry *logrus.Entry) error {
+	if id := RequestID(entry.Context); id != "" {
+		entry.Data["request_id"] = id
+	}
+	if span := trace.SpanContextFromContext(entry.Context); span.IsValid() {
+		entry.Data["trace_id"] = span.TraceID().String()
+	}
+	return nil
 }
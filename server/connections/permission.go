@@ -0,0 +1,36 @@
+package connections
+
+import (
+	"compass/model"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// seedPermissions inserts the permissions added since the last start along with their default roles
+func seedPermissions() {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		for _, perm := range model.DefaultPermissions {
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&perm.Def)
+			if result.Error != nil {
+				return result.Error
+			}
+			// Already known, the grants may have been changed on purpose
+			if result.RowsAffected == 0 {
+				continue
+			}
+			for _, role := range perm.Roles {
+				grant := model.RolePermission{Role: role, Permission: perm.Def.Name}
+				if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Omit(clause.Associations).Create(&grant).Error; err != nil {
+					return err
+				}
+			}
+			logrus.Infof("Added permission %s", perm.Def.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.Fatal("Failed to seed permissions: ", err)
+	}
+}
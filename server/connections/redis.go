@@ -0,0 +1,33 @@
+// File for connecting the application to redis (or a redis compatible store like valkey)
+// you may access the client anywhere in the application using connections.Redis, it is nil when redis.host is empty
+package connections
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var Redis *redis.Client
+
+func redisConnection() {
+	host := viper.GetString("redis.host")
+	if host == "" {
+		// Only the shared rate limits need it, a single instance does fine without
+		return
+	}
+	Redis = redis.NewClient(&redis.Options{
+		Addr:     host + ":" + viper.GetString("redis.port"),
+		Password: viper.GetString("redis.password"),
+		DB:       viper.GetInt("redis.db"),
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := Redis.Ping(ctx).Err(); err != nil {
+		logrus.Fatal("Failed to connect to redis: ", err)
+	}
+	logrus.Info("Connected to redis")
+}
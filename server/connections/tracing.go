@@ -0,0 +1,76 @@
+// Set up OpenTelemetry tracing, the spans of a request follow it through the queues into the workers
+package connections
+
+import (
+	"context"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer is used for the spans we start ourselves (like the queue jobs), the libraries bring their own
+var Tracer trace.Tracer = otel.Tracer("compass")
+
+var tracerProvider *sdktrace.TracerProvider
+
+// tracingConfig picks the exporter from tracing.exporter: none, stdout (local use) or otlp
+func tracingConfig() {
+	// W3C traceparent, used for the http calls as well as the rabbitmq headers
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch viper.GetString("tracing.exporter") {
+	case "", "none":
+		// The global provider stays a noop, the instrumentation costs next to nothing
+		return
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case "otlp":
+		options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(viper.GetString("tracing.endpoint"))}
+		if viper.GetBool("tracing.insecure") {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), options...)
+	default:
+		logrus.Fatalf("Unknown tracing exporter %q", viper.GetString("tracing.exporter"))
+	}
+	if err != nil {
+		logrus.Fatal("Failed to create the trace exporter: ", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(viper.GetString("tracing.serviceName")),
+		semconv.DeploymentEnvironmentName(viper.GetString("env")),
+	))
+	if err != nil {
+		logrus.Fatal("Failed to create the trace resource: ", err)
+	}
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		// Follow the decision of the caller, else keep tracing.sampleRatio of the new traces
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(viper.GetFloat64("tracing.sampleRatio")))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	logrus.Infof("Tracing enabled, exporting to %s", viper.GetString("tracing.exporter"))
+}
+
+// ShutdownTracing flushes the spans still in the batch, call it before the process exits
+func ShutdownTracing(ctx context.Context) {
+	if tracerProvider == nil {
+		return
+	}
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		logrus.Error("Failed to flush traces: ", err)
+	}
+}
@@ -10,9 +10,9 @@ import (
 	"github.com/spf13/viper"
 )
 
-func viperConfig() {
+func viperConfig(dir string) {
 	viper.SetConfigType("yaml")
-	viper.AddConfigPath("./")
+	viper.AddConfigPath(dir)
 
 	viper.SetConfigName("config")
 	err := viper.ReadInConfig()
@@ -34,7 +34,10 @@ func viperConfig() {
 	// Viper does not live-watch environment variables, so if you update the env then also it will be the same as run time
 	// hence add a route in the admin side to update it (specially for api keys)
 	if viper.BindEnv("database.host", "POSTGRES_HOST") != nil ||
-		viper.BindEnv("rabbitmq.host", "RABBITMQ_HOST") != nil {
+		viper.BindEnv("rabbitmq.host", "RABBITMQ_HOST") != nil ||
+		viper.BindEnv("redis.host", "REDIS_HOST") != nil ||
+		viper.BindEnv("oa.provider", "OA_PROVIDER") != nil ||
+		viper.BindEnv("password.breachedCorpus", "BREACHED_CORPUS") != nil {
 		logrus.Error(("Error connecting to env variables"))
 	}
 }
@@ -3,7 +3,9 @@ package maps
 import (
 	"compass/assets"
 	"compass/connections"
+	"compass/middleware"
 	"compass/model"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -20,13 +22,13 @@ func flagAction(c *gin.Context) {
 
 	var req FlagActionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid request"})
+		middleware.Fail(c, middleware.InvalidInput(err))
 		return
 	}
 
 	var review model.Review
-	if err := connections.DB.Where("id = ?", reviewID).First(&review).Error; err != nil {
-		c.JSON(404, gin.H{"error": "Review not found"})
+	if err := middleware.DB(c).Where("id = ?", reviewID).First(&review).Error; err != nil {
+		middleware.Fail(c, middleware.NotFound("Review not found"))
 		return
 	}
 
@@ -35,14 +37,16 @@ func flagAction(c *gin.Context) {
 		review.Status = "approved"
 		//update ratting of the location
 		var location model.Location
-		if err := connections.DB.Where("id = ?", review.LocationId).First(&location); err != nil {
-			c.JSON(400, gin.H{"error": "error while updating the location review count"})
+		if err := middleware.DB(c).Where("id = ?", review.LocationId).First(&location).Error; err != nil {
+			middleware.Fail(c, middleware.Internal(err, "Failed to update the location review count"))
+			return
 		}
 		location.ReviewCount += 1
 		location.AverageRating = ((location.AverageRating * float32(location.ReviewCount-1)) + float32(review.Rating)) / float32(location.ReviewCount)
 
-		if err := connections.DB.Save(&location).Error; err != nil {
-			c.JSON(400, gin.H{"error": "error while updating the location review count"})
+		if err := middleware.DB(c).Save(&location).Error; err != nil {
+			middleware.Fail(c, middleware.Internal(err, "Failed to update the location review count"))
+			return
 		}
 		c.JSON(200, gin.H{"message": "Review approved"})
 
@@ -51,13 +55,13 @@ func flagAction(c *gin.Context) {
 
 	if req.Action == "rejected" {
 		if req.Message == "" {
-			c.JSON(400, gin.H{"error": "Rejection message required"})
+			middleware.Fail(c, middleware.BadRequest("Rejection message required"))
 			return
 		}
 
 		review.Status = "rejected"
-		if err := connections.DB.Save(&review).Error; err != nil {
-			c.JSON(500, gin.H{"error": "Failed to update review status"})
+		if err := middleware.DB(c).Save(&review).Error; err != nil {
+			middleware.Fail(c, middleware.Internal(err, "Failed to update review status"))
 			return
 		}
 		connections.MQChannel.Publish(
@@ -164,19 +168,19 @@ func addNotice(c *gin.Context) {
 	var input AddNoticeRequest
 
 	if err := c.ShouldBindJSON(&input); err != nil {
-		logrus.WithError(err).Warn("JSON binding failed")
+		logrus.WithContext(c.Request.Context()).WithError(err).Warn("JSON binding failed")
 
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		middleware.Fail(c, middleware.InvalidInput(err))
 		return
 	}
 
 	userID, exist := c.Get("userID") // means api requests must be authenticated
 	if !exist {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
 		return
 	}
 
-	if err := connections.DB.Transaction(func(tx *gorm.DB) error {
+	if err := middleware.DB(c).Transaction(func(tx *gorm.DB) error {
 		// Create notice
 		notice := model.Notice{
 			Title:         input.Title,
@@ -222,10 +226,62 @@ func addNotice(c *gin.Context) {
 		}
 		return nil
 	}); err != nil {
-		logrus.Error("Failed to create notice:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		logrus.WithContext(c.Request.Context()).Error("Failed to create notice:", err)
+		middleware.Fail(c, middleware.Internal(err, "Failed to add notice"))
 		return
 	}
 	// TODO: publish a mail confirming notice published
 	c.JSON(201, gin.H{"message": "New notice added successfully"})
 }
+
+// userRoleAction changes the role of a user, nobody can hand out or take away a role above their own
+func userRoleAction(c *gin.Context) {
+	var req UserRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil || !model.ValidRole(req.Role) {
+		middleware.Fail(c, middleware.BadRequest("Invalid role"))
+		return
+	}
+	targetID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.Fail(c, middleware.BadRequest("Invalid user ID format"))
+		return
+	}
+	actorID, exist := c.Get("userID")
+	if !exist {
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
+		return
+	}
+	if actorID.(uuid.UUID) == targetID {
+		middleware.Fail(c, middleware.Forbidden("You can't change your own role"))
+		return
+	}
+
+	actorRole := model.Role(c.GetInt("userRole"))
+	var target model.User
+	if err := middleware.DB(c).Model(&model.User{}).Select("user_id", "email", "role").
+		Where("user_id = ?", targetID).First(&target).Error; err != nil {
+		middleware.Fail(c, middleware.NotFound("User not found"))
+		return
+	}
+	if target.Role > actorRole || req.Role > actorRole {
+		middleware.Fail(c, middleware.Forbidden("Insufficient permissions"))
+		return
+	}
+	if err := middleware.DB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.User{}).Where("user_id = ?", targetID).Update("role", req.Role).Error; err != nil {
+			return err
+		}
+		entry := model.NewLog(
+			"Role changed",
+			fmt.Sprintf("Role of %s changed from %d to %d by %s", target.Email, target.Role, req.Role, actorID),
+			model.AdminActor,
+		)
+		return tx.Create(&entry).Error
+	}); err != nil {
+		logrus.WithContext(c.Request.Context()).Error("Failed to change role: ", err)
+		middleware.Fail(c, middleware.Internal(err, "Failed to change role"))
+		return
+	}
+	// The access token carries the role, the change applies with the next refresh (within the token expiry)
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated"})
+}
@@ -0,0 +1,139 @@
+package maps
+
+import (
+	"compass/middleware"
+	"compass/model"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// apiKeysProvider lists the api keys, the keys themselves are never shown again
+func apiKeysProvider(c *gin.Context) {
+	var keys []model.APIKey
+	if err := middleware.DB(c).Order("created_at DESC").Find(&keys).Error; err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to fetch api keys"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// createAPIKey mints a key acting as the given account, usually one with model.Bot role
+func createAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.Fail(c, middleware.InvalidInput(err))
+		return
+	}
+	actorID, exist := c.Get("userID")
+	if !exist {
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
+		return
+	}
+
+	var owner model.User
+	if err := middleware.DB(c).Model(&model.User{}).Select("user_id", "email", "role").
+		Where("user_id = ?", req.UserID).First(&owner).Error; err != nil {
+		middleware.Fail(c, middleware.NotFound("User not found"))
+		return
+	}
+	if owner.Role > model.Role(c.GetInt("userRole")) {
+		middleware.Fail(c, middleware.Forbidden("Insufficient permissions"))
+		return
+	}
+	// A key can't do more than its account
+	for _, scope := range req.Scopes {
+		ok, err := middleware.HasPermission(c.Request.Context(), owner.Role, scope)
+		if err != nil {
+			middleware.Fail(c, middleware.Internal(err, "Database error"))
+			return
+		}
+		if !ok {
+			middleware.Fail(c, middleware.BadRequest(fmt.Sprintf("The account does not have the permission %s", scope)))
+			return
+		}
+	}
+
+	// A key is as strong as the request minting it, an api key request never passed the second factor itself
+	mintedWithTwoFactor := c.GetBool("twoFactor") && !middleware.IsAPIKeyRequest(c)
+	// A key of another admin account would let its holder past the 2FA of that account
+	if owner.Role >= model.AdminRole && owner.UserID != actorID.(uuid.UUID) && !mintedWithTwoFactor {
+		middleware.Fail(c, middleware.Forbidden("Login with two factor authentication to mint a key for another admin account").WithCode(middleware.CodeTwoFactorRequired))
+		return
+	}
+
+	key, prefix, hash, err := middleware.NewAPIKey()
+	if err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to generate api key"))
+		return
+	}
+	apiKey := model.APIKey{
+		KeyID:               uuid.New(),
+		Name:                req.Name,
+		Prefix:              prefix,
+		KeyHash:             hash,
+		UserID:              owner.UserID,
+		Scopes:              req.Scopes,
+		CreatedBy:           actorID.(uuid.UUID),
+		MintedWithTwoFactor: mintedWithTwoFactor,
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+	if err := middleware.DB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&apiKey).Error; err != nil {
+			return err
+		}
+		entry := model.NewLog(
+			"API key created",
+			fmt.Sprintf("Key %s (%s) for %s with scopes %v created by %s", apiKey.Name, apiKey.Prefix, owner.Email, apiKey.Scopes, actorID),
+			model.AdminActor,
+		)
+		return tx.Create(&entry).Error
+	}); err != nil {
+		logrus.WithContext(c.Request.Context()).Error("Failed to create api key: ", err)
+		middleware.Fail(c, middleware.Internal(err, "Failed to create api key"))
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "API key created, copy it now, it will not be shown again",
+		"key":     key,
+		"apiKey":  apiKey,
+	})
+}
+
+// revokeAPIKey stops a key immediately
+func revokeAPIKey(c *gin.Context) {
+	keyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.Fail(c, middleware.BadRequest("Invalid key ID format"))
+		return
+	}
+	actorID, _ := c.Get("userID")
+	var apiKey model.APIKey
+	if err := middleware.DB(c).Where("key_id = ? AND revoked_at IS NULL", keyID).First(&apiKey).Error; err != nil {
+		middleware.Fail(c, middleware.NotFound("API key not found"))
+		return
+	}
+	if err := middleware.DB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&apiKey).Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		entry := model.NewLog(
+			"API key revoked",
+			fmt.Sprintf("Key %s (%s) revoked by %s", apiKey.Name, apiKey.Prefix, actorID),
+			model.AdminActor,
+		)
+		return tx.Create(&entry).Error
+	}); err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to revoke api key"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
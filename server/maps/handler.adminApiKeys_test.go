@@ -0,0 +1,93 @@
+package maps
+
+import (
+	"bytes"
+	"compass/connections"
+	"compass/middleware"
+	"compass/model"
+	"compass/testutil"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// TestMain loads the config of the server without connecting to its services, the tests set up the DB they use
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	connections.LoadConfig("../")
+	middleware.Init()
+	os.Exit(m.Run())
+}
+
+func createUser(t *testing.T, role model.Role) model.User {
+	t.Helper()
+	user := model.User{Email: uuid.NewString() + "@iitk.ac.in", IsVerified: true, Role: role}
+	if err := connections.DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// mintKey calls createAPIKey as the actor, logged in with or without the second factor, the owner must have no key yet
+func mintKey(t *testing.T, actor model.User, twoFactor bool, owner model.User) (int, model.APIKey) {
+	t.Helper()
+	r := gin.New()
+	r.Use(middleware.Errors())
+	r.POST("/apikeys", func(c *gin.Context) {
+		c.Set("userID", actor.UserID)
+		c.Set("userRole", int(actor.Role))
+		c.Set("twoFactor", twoFactor)
+		c.Next()
+	}, createAPIKey)
+	body, _ := json.Marshal(CreateAPIKeyRequest{Name: "deploy", UserID: owner.UserID})
+	req := httptest.NewRequest(http.MethodPost, "/apikeys", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var apiKey model.APIKey
+	if w.Code == http.StatusCreated {
+		if err := connections.DB.First(&apiKey, "user_id = ?", owner.UserID).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, apiKey
+}
+
+func TestCreateAPIKeyRecordsTheTwoFactorOfTheMinting(t *testing.T) {
+	testutil.UseDB(t)
+	superAdmin := createUser(t, model.SuperAdminRole)
+	bot := createUser(t, model.Bot)
+
+	code, apiKey := mintKey(t, superAdmin, false, superAdmin)
+	if code != http.StatusCreated || apiKey.MintedWithTwoFactor {
+		t.Errorf("own key without 2FA status = %d, minted with 2FA %v, want 201 and false", code, apiKey.MintedWithTwoFactor)
+	}
+	code, apiKey = mintKey(t, superAdmin, true, bot)
+	if code != http.StatusCreated || !apiKey.MintedWithTwoFactor {
+		t.Errorf("bot key with 2FA status = %d, minted with 2FA %v, want 201 and true", code, apiKey.MintedWithTwoFactor)
+	}
+	code, apiKey = mintKey(t, superAdmin, false, createUser(t, model.Bot))
+	if code != http.StatusCreated || apiKey.MintedWithTwoFactor {
+		t.Errorf("bot key without 2FA status = %d, minted with 2FA %v, want 201 and false", code, apiKey.MintedWithTwoFactor)
+	}
+}
+
+func TestCreateAPIKeyForAnotherAdminNeedsTwoFactor(t *testing.T) {
+	testutil.UseDB(t)
+	superAdmin := createUser(t, model.SuperAdminRole)
+	admin := createUser(t, model.AdminRole)
+
+	if code, _ := mintKey(t, superAdmin, false, admin); code != http.StatusForbidden {
+		t.Errorf("admin key minted without 2FA status = %d, want 403", code)
+	}
+	code, apiKey := mintKey(t, superAdmin, true, admin)
+	if code != http.StatusCreated || !apiKey.MintedWithTwoFactor {
+		t.Errorf("admin key minted with 2FA status = %d, minted with 2FA %v, want 201 and true", code, apiKey.MintedWithTwoFactor)
+	}
+}
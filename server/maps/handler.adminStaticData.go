@@ -2,6 +2,7 @@ package maps
 
 import (
 	"compass/connections"
+	"compass/middleware"
 	"compass/model"
 	"net/http"
 
@@ -17,21 +18,17 @@ func flaggedReviewsProvider(c *gin.Context) {
 
 	var reviews []model.Review
 
-	if err := connections.DB.
+	if err := middleware.DB(c).
 		WithContext(c.Request.Context()).
 		Select("id, content, status").
 		Table("reviews").
 		Where("status = ?", "rejected_by_bot").
 		Find(&reviews).Error; err != nil {
-		c.JSON(500, gin.H{"error": "Database error", "details": err.Error()})
-		return
-	}
-
-	if len(reviews) == 0 {
-		c.JSON(404, gin.H{"message": "No flagged reviews found"})
+		middleware.Fail(c, middleware.Internal(err, "Failed to fetch flagged reviews"))
 		return
 	}
 
+	// No flagged reviews is an empty list, not a missing resource
 	c.JSON(200, gin.H{"flagged_reviews": reviews})
 }
 
@@ -40,7 +37,7 @@ func locationRequestProvider(c *gin.Context) {
 	var pending []model.Location
 
 	// Here only admin can accept or reject so just check pending
-	err := connections.DB.
+	err := middleware.DB(c).
 		Model(&model.Location{}).
 		Preload("User", connections.UserSelect).
 		Preload("CoverPic", connections.ImageSelect).
@@ -48,7 +45,7 @@ func locationRequestProvider(c *gin.Context) {
 		Find(&pending).Error
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pending location requests"})
+		middleware.Fail(c, middleware.Internal(err, "Failed to fetch pending location requests"))
 		return
 	}
 
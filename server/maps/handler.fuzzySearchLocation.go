@@ -1,7 +1,7 @@
 package maps
 
 import (
-	"compass/connections"
+	"compass/middleware"
 	"compass/model"
 	"net/http"
 	"strconv"
@@ -13,7 +13,7 @@ func FuzzySearchLocationsHandler(c *gin.Context) {
 	// Getting query param
 	query := c.Query("query")
 	if query == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "query is required"})
+		middleware.Fail(c, middleware.BadRequest("query is required"))
 		return
 	}
 
@@ -25,7 +25,7 @@ func FuzzySearchLocationsHandler(c *gin.Context) {
 	}
 
 	var locations []model.Location
-	db := connections.DB
+	db := middleware.DB(c)
 
 	// Fuzzy search using similarity
 	// TODO: Can and Need to extend to description, better search logic here.
@@ -38,7 +38,7 @@ func FuzzySearchLocationsHandler(c *gin.Context) {
     `, query, query, limit).Scan(&locations).Error
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch locations", "details": err.Error()})
+		middleware.Fail(c, middleware.Internal(err, "Failed to fetch locations"))
 		return
 	}
 
@@ -1,7 +1,7 @@
 package maps
 
 import (
-	"compass/connections"
+	"compass/middleware"
 	"compass/model"
 	"net/http"
 	"github.com/gin-gonic/gin"
@@ -12,7 +12,7 @@ func FuzzySearchNoticesHandler(c *gin.Context) {
 	
 	query := c.Query("query")
 	if query == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "query is required"})
+		middleware.Fail(c, middleware.BadRequest("query is required"))
 		return
 	}
 
@@ -24,7 +24,7 @@ func FuzzySearchNoticesHandler(c *gin.Context) {
 	}
 
 	var notices []model.Notice
-	db := connections.DB
+	db := middleware.DB(c)
 
 	err := db.Raw(`
 		SELECT *, 
@@ -36,7 +36,7 @@ func FuzzySearchNoticesHandler(c *gin.Context) {
 	`, query, query, query, query, query, query, limit).Scan(&notices).Error
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notices", "details": err.Error()})
+		middleware.Fail(c, middleware.Internal(err, "Failed to fetch notices"))
 		return
 	}
 
@@ -1,7 +1,7 @@
 package maps
 
 import (
-	"compass/connections"
+	"compass/middleware"
 	"compass/model"
 	"compass/workers"
 	"encoding/json"
@@ -17,13 +17,13 @@ import (
 func addReview(c *gin.Context) {
 	var req AddReviewRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		middleware.Fail(c, middleware.InvalidInput(err))
 		return
 	}
 	// TODO: Extract this logic out, need something more elegant
 	userID, exist := c.Get("userID")
 	if !exist {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
 		return
 	}
 	// TODO: If the location is not yet approved but somehow the hacker is trying to add location
@@ -33,7 +33,7 @@ func addReview(c *gin.Context) {
 	var images []model.Image
 	// TODO: Empty Review should not be allowed
 	// Transaction will combine all steps and will do nothing if any error occurs
-	if err := connections.DB.Transaction(func(tx *gorm.DB) error {
+	if err := middleware.DB(c).Transaction(func(tx *gorm.DB) error {
 		// Create review
 		if err := tx.Create(&newReview).Error; err != nil {
 			return err
@@ -50,7 +50,7 @@ func addReview(c *gin.Context) {
 		}
 		return nil
 	}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to process review addition"})
+		middleware.Fail(c, middleware.Internal(err, "Unable to process review addition"))
 		fmt.Print(err)
 		return
 	}
@@ -60,8 +60,8 @@ func addReview(c *gin.Context) {
 		AssetID: newReview.ReviewId,
 		Type:    model.ModerationTypeReviewText,
 	})
-	if err := workers.PublishJob(payload, model.ModerationQueue); err != nil {
-		logrus.Infof("Unable to publish text moderation job for review id: %s", newReview.ReviewId)
+	if err := workers.PublishJob(c.Request.Context(), payload, model.ModerationQueue); err != nil {
+		logrus.WithContext(c.Request.Context()).Infof("Unable to publish text moderation job for review id: %s", newReview.ReviewId)
 		unableToModerate++
 	}
 
@@ -71,8 +71,8 @@ func addReview(c *gin.Context) {
 			AssetID: img.ImageID,
 			Type:    model.ModerationTypeImage,
 		})
-		if err := workers.PublishJob(payload, model.ModerationQueue); err != nil {
-			logrus.Infof("Unable to publish image moderation job for image id: %d", img.ImageID)
+		if err := workers.PublishJob(c.Request.Context(), payload, model.ModerationQueue); err != nil {
+			logrus.WithContext(c.Request.Context()).Infof("Unable to publish image moderation job for image id: %d", img.ImageID)
 			unableToModerate++
 			continue
 		}
@@ -93,24 +93,24 @@ func addReview(c *gin.Context) {
 func requestLocationAddition(c *gin.Context) {
 	var req AddLocationRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		middleware.Fail(c, middleware.InvalidInput(err))
 		return
 	}
 	// TODO: Extract this logic out, need something more elegant
 	userID, exist := c.Get("userID")
 	if !exist {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		middleware.Fail(c, middleware.Unauthorized("Unauthorized"))
 		return
 	}
 	// Get the new location model
 	newLocation := req.ToLocation(userID.(uuid.UUID))
 	var missingCount int
 	if len(newLocation.Name) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Place Name"})
+		middleware.Fail(c, middleware.BadRequest("Invalid Place Name"))
 		return
 	}
 	// Transaction will combine all steps and will do nothing if any error occurs
-	if err := connections.DB.Transaction(func(tx *gorm.DB) error {
+	if err := middleware.DB(c).Transaction(func(tx *gorm.DB) error {
 		// Create location
 		if err := tx.Create(&newLocation).Error; err != nil {
 			return err
@@ -139,7 +139,7 @@ func requestLocationAddition(c *gin.Context) {
 		}
 		return nil
 	}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to request location addition"})
+		middleware.Fail(c, middleware.Internal(err, "Unable to request location addition"))
 		return
 	}
 	if missingCount > 0 {
@@ -2,7 +2,9 @@ package maps
 
 import (
 	"compass/connections"
+	"compass/middleware"
 	"compass/model"
+	"context"
 	"net/http"
 	"strconv"
 	"errors"
@@ -24,21 +26,21 @@ func noticeProvider(c *gin.Context) {
 	offset := (page - 1) * viper.GetInt("noticeboard.limit")
 
 	var noticeList []model.Notice
-	if connections.DB.
+	if err := middleware.DB(c).
 		Model(&model.Notice{}).
 		Preload("User", connections.UserSelect).
 		Order("created_at DESC").
 		Limit(viper.GetInt("noticeboard.limit")).
 		Offset(offset). // set page
 		Find(&noticeList).
-		Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notices"})
+		Error; err != nil {
+		middleware.Fail(c, middleware.Internal(err, "Failed to fetch notices"))
 		return
 	}
 	// Count total pages
 	var count int64 = -1
-	if err := connections.DB.Model(&model.Notice{}).Count(&count).Error; err != nil {
-		logrus.Errorf("Failed to count notices: %v", err)
+	if err := middleware.DB(c).Model(&model.Notice{}).Count(&count).Error; err != nil {
+		logrus.WithContext(c.Request.Context()).Errorf("Failed to count notices: %v", err)
 		return
 	}
 	// TODO: handling if count is -1, then don't show the count field, there is some error
@@ -56,13 +58,13 @@ func noticeDetailProvider(c *gin.Context) {
     noticeIDStr := c.Param("id")
     noticeID, err := uuid.Parse(noticeIDStr)
     if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notice ID format"})
+        middleware.Fail(c, middleware.BadRequest("Invalid notice ID format"))
         return
     }
 
     // Query the database for the notice, preloading the User
     var notice model.Notice
-    result := connections.DB.
+    result := middleware.DB(c).
         Model(&model.Notice{}).
         Preload("User", connections.UserSelect). // Preload user data, just like in noticeProvider
         Where("notice_id = ?", noticeID).
@@ -71,11 +73,11 @@ func noticeDetailProvider(c *gin.Context) {
     // Handle any errors from the database query
     if result.Error != nil {
         if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-            c.JSON(http.StatusNotFound, gin.H{"error": "Notice not found"})
+            middleware.Fail(c, middleware.NotFound("Notice not found"))
             return
         }
 
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notice"})
+        middleware.Fail(c, middleware.Internal(err, "Failed to fetch notice"))
         return
     }
 
@@ -93,12 +95,12 @@ func incrementalLocationProvider(c *gin.Context) {
 	// If since time is empty, provide all locations
 	if sinceStr == "" {
 		var locs []model.Location
-		if err := connections.DB.
+		if err := middleware.DB(c).
 			Model(&model.Location{}).
 			Where("status = ?", model.Approved).
 			Select("location_id", "name", "latitude", "longitude", "updated_at", "location_type").
 			Find(&locs).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch locations"})
+			middleware.Fail(c, middleware.Internal(err, "Failed to fetch locations"))
 			return
 		}
 
@@ -112,7 +114,7 @@ func incrementalLocationProvider(c *gin.Context) {
 
 	since, err := time.Parse(time.RFC3339, sinceStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since timestamp"})
+		middleware.Fail(c, middleware.BadRequest("Invalid since timestamp"))
 		return
 	}
 
@@ -121,21 +123,21 @@ func incrementalLocationProvider(c *gin.Context) {
 		deleted []deletedLocationResp
 	)
 
-	if err := connections.DB.
+	if err := middleware.DB(c).
 		Model(&model.Location{}).
 		Where("status = ? AND updated_at > ?", model.Approved, since).
 		Select("location_id", "name", "latitude", "longitude", "updated_at", "location_type").
 		Find(&updated).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch updated locations"})
+		middleware.Fail(c, middleware.Internal(err, "Failed to fetch updated locations"))
 		return
 	}
 
-	if err := connections.DB.Unscoped().
+	if err := middleware.DB(c).Unscoped().
 		Model(&model.Location{}).
 		Where("deleted_at > ?", since).
 		Select("location_id", "deleted_at").
 		Scan(&deleted).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deleted locations"})
+		middleware.Fail(c, middleware.Internal(err, "Failed to fetch deleted locations"))
 		return
 	}
 
@@ -165,7 +167,7 @@ func incrementalLocationProvider(c *gin.Context) {
 func locationDetailProvider(c *gin.Context) {
 	id := c.Param("id")
 	var loc model.Location
-	if err := connections.DB.
+	if err := middleware.DB(c).
 		Model(&model.Location{}).
 		Preload("User", connections.UserSelect). // Location contributor
 		Preload("Reviews", func(db *gorm.DB) *gorm.DB {
@@ -176,7 +178,7 @@ func locationDetailProvider(c *gin.Context) {
 		Preload("Reviews.User", connections.UserSelect). // Review contributors
 		Where("location_id = ? AND status = ?", id, model.Approved).
 		First(&loc).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Error Fetching location"})
+		middleware.Fail(c, middleware.NotFound("Error Fetching location"))
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"location": loc})
@@ -187,7 +189,7 @@ func reviewProvider(c *gin.Context) {
 	locationID := c.Param("id")
 
 	if locationID == "" {
-		c.JSON(400, gin.H{"error": "location_id is required"})
+		middleware.Fail(c, middleware.BadRequest("location_id is required"))
 		return
 	}
 
@@ -195,7 +197,7 @@ func reviewProvider(c *gin.Context) {
 	limit := 50
 	if p := c.Param("page"); p != "" {
 		if parsedPage, err := strconv.Atoi(p); err != nil || parsedPage < 1 {
-			c.JSON(400, gin.H{"error": "invalid page parameter"})
+			middleware.Fail(c, middleware.BadRequest("invalid page parameter"))
 			return
 		} else {
 			page = parsedPage
@@ -204,9 +206,9 @@ func reviewProvider(c *gin.Context) {
 
 	offset := (page - 1) * limit
 
-	reviews, total, err := fetchReviewsByLocationID(locationID, limit, offset)
+	reviews, total, err := fetchReviewsByLocationID(c.Request.Context(), locationID, limit, offset)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "failed to fetch reviews"})
+		middleware.Fail(c, middleware.Internal(err, "failed to fetch reviews"))
 		return
 	}
 
@@ -219,10 +221,10 @@ func reviewProvider(c *gin.Context) {
 	})
 }
 
-func fetchReviewsByLocationID(locationID string, limit, offset int) ([]model.Review, int, error) {
+func fetchReviewsByLocationID(ctx context.Context, locationID string, limit, offset int) ([]model.Review, int, error) {
 	var reviews []model.Review
 	var total int64
-	db := connections.DB
+	db := middleware.DB(ctx)
 
 	if err := db.Model(&model.Review{}).Where("location_id = ?", locationID).Count(&total).Error; err != nil {
 		return nil, 0, err
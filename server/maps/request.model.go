@@ -66,3 +66,14 @@ type FlagActionRequest struct {
 	Action  string `json:"action" binding:"required,oneof=approved rejected"`
 	Message string `json:"message"`
 }
+
+type UserRoleRequest struct {
+	Role model.Role `json:"role" binding:"required"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name          string             `json:"name" binding:"required,max=100"`
+	UserID        uuid.UUID          `json:"userId" binding:"required"` // account the key acts as
+	Scopes        []model.Permission `json:"scopes"`
+	ExpiresInDays int                `json:"expiresInDays" binding:"min=0"` // 0 never expires
+}
@@ -3,6 +3,7 @@ package maps
 
 import (
 	"compass/middleware"
+	"compass/model"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,35 +13,42 @@ func Router(r *gin.Engine) {
 	{
 		// Public routes, will not require login, static data providers
 		// use https://gin-gonic.com/en/docs/examples/param-in-path/ and structure the paths to support specific id, and pagination
-		maps.GET("/notice", noticeProvider)         // each page will provide 10 notices (all the details about the notices)
-		maps.GET("/notice/:id", noticeDetailProvider)
-		maps.GET("/location/:id", locationDetailProvider) // provide exact details about the location using the id
-		maps.GET("/locations/incremental", incrementalLocationProvider) // incremental location updates
-		maps.GET("/reviews/:id/:page", reviewProvider)    // provide the reviews of the location id, most recent 50, if there are more do the pagination
-        maps.GET("/location/fuzzy", FuzzySearchLocationsHandler)
-        maps.GET("/notice/fuzzy", FuzzySearchNoticesHandler)
+		browse := middleware.RateLimit("browse") // by ip, these don't look at the login
+		maps.GET("/notice", browse, noticeProvider)         // each page will provide 10 notices (all the details about the notices)
+		maps.GET("/notice/:id", browse, noticeDetailProvider)
+		maps.GET("/location/:id", browse, locationDetailProvider) // provide exact details about the location using the id
+		maps.GET("/locations/incremental", browse, incrementalLocationProvider) // incremental location updates
+		maps.GET("/reviews/:id/:page", browse, reviewProvider)    // provide the reviews of the location id, most recent 50, if there are more do the pagination
+        maps.GET("/location/fuzzy", middleware.RateLimit("fuzzy"), FuzzySearchLocationsHandler)
+        maps.GET("/notice/fuzzy", middleware.RateLimit("fuzzy"), FuzzySearchNoticesHandler)
 												
 		// User-protected routes
 		user := maps.Group("/")
-		user.Use(middleware.UserAuthenticator, middleware.EmailVerified)
+		user.Use(middleware.UserAuthenticator, middleware.EmailVerified, middleware.RateLimit("contribution"))
 		user.POST("/review", addReview)                 // add a review in the rabbit mq queue for processing
 		user.POST("/location", requestLocationAddition) // add a location request in the table
 
 		// Next we will add user navigation, and location sharing feature
 		// ...
 
-		// Admin-protected routes
+		// Dashboard routes, each needs a permission of the user's role (see model.DefaultPermissions).
+		// Bots and service accounts can call them with an api key scoped to the permission.
 		admin := maps.Group("/")
+		admin.Use(middleware.AcceptAPIKeys, middleware.UserAuthenticator)
 		// Static data on dashboard
-		admin.Use(middleware.UserAuthenticator, middleware.AdminAuthenticator)
-		admin.GET("/logs", systemLogsProvider)
-		admin.GET("/flag", flaggedReviewsProvider)
-		admin.GET("/newLocation", locationRequestProvider)
-		admin.GET("/indicators", indicatorProvider)
+		admin.GET("/logs", middleware.RequirePermission(model.DashboardViewPermission), systemLogsProvider)
+		admin.GET("/flag", middleware.RequirePermission(model.ReviewModeratePermission), flaggedReviewsProvider)
+		admin.GET("/newLocation", middleware.RequirePermission(model.LocationApprovePermission), locationRequestProvider)
+		admin.GET("/indicators", middleware.RequirePermission(model.DashboardViewPermission), indicatorProvider)
 		// Actions
-		admin.POST("/flag/:id", flagAction)         // Allow action like allow or declined, in case of negative action add a mail request in the queue for the mail worker to send a mail of rejection to the user
-		admin.POST("/location/:id", locationAction) // Allow the action of user like allow or declined
-		admin.POST("/notice", addNotice)
+		admin.POST("/flag/:id", middleware.RequirePermission(model.ReviewModeratePermission), flagAction)         // Allow action like allow or declined, in case of negative action add a mail request in the queue for the mail worker to send a mail of rejection to the user
+		admin.POST("/location/:id", middleware.RequirePermission(model.LocationApprovePermission), locationAction) // Allow the action of user like allow or declined
+		admin.POST("/notice", middleware.RequirePermission(model.NoticePublishPermission), addNotice)
+		admin.POST("/user/:id/role", middleware.RequirePermission(model.UserManagePermission), userRoleAction)
+		// API keys for bots and service accounts
+		admin.GET("/apikeys", middleware.RequirePermission(model.UserManagePermission), apiKeysProvider)
+		admin.POST("/apikeys", middleware.RequirePermission(model.UserManagePermission), createAPIKey)
+		admin.DELETE("/apikeys/:id", middleware.RequirePermission(model.UserManagePermission), revokeAPIKey)
 		// TODO: add a env reload route for admin
 
 	}
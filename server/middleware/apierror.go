@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"compass/connections"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// ErrorCode is the machine readable part of an error response, the frontend switches on it instead of the message
+type ErrorCode string
+
+const (
+	CodeBadRequest   ErrorCode = "bad_request"
+	CodeInvalidInput ErrorCode = "invalid_input" // body or query failed the validation, details has the fields
+	CodeUnauthorized ErrorCode = "unauthorized"
+	CodeForbidden    ErrorCode = "forbidden"
+	CodeNotFound     ErrorCode = "not_found"
+	CodeConflict     ErrorCode = "conflict"
+	CodeGone         ErrorCode = "gone"
+	CodeRateLimited  ErrorCode = "rate_limited"
+	CodeInternal     ErrorCode = "internal"
+	CodeUnavailable  ErrorCode = "unavailable"
+
+	// Codes the frontend acts on
+	CodeSessionExpired    ErrorCode = "session_expired"     // login again
+	CodeTwoFactorRequired ErrorCode = "two_factor_required" // verify the second factor to continue
+	CodeImpersonating     ErrorCode = "impersonating"       // not allowed while impersonating
+	CodeReauthRequired    ErrorCode = "reauth_required"     // login again, the change needs a recent login
+	CodeInvalidCSRF       ErrorCode = "invalid_csrf"        // refresh the csrf token and retry
+	CodeCaptchaFailed     ErrorCode = "captcha_failed"
+	CodeWeakPassword      ErrorCode = "weak_password" // details has the problems by field
+)
+
+// APIError is the error envelope of every failed api call: {code, message, details, requestId}.
+// Message is shown to the user, the cause (like a db error) is only logged.
+type APIError struct {
+	Status    int       `json:"-"`
+	Code      ErrorCode `json:"code"`
+	Message   string    `json:"message"`
+	Details   any       `json:"details,omitempty"`
+	RequestID string    `json:"requestId,omitempty"`
+	cause     error
+}
+
+func (e *APIError) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.cause)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.cause
+}
+
+// WithCode replaces the generic code of the status with a more specific one
+func (e *APIError) WithCode(code ErrorCode) *APIError {
+	e.Code = code
+	return e
+}
+
+// WithDetails attaches data the client may use, never anything internal
+func (e *APIError) WithDetails(details any) *APIError {
+	e.Details = details
+	return e
+}
+
+func NewAPIError(status int, code ErrorCode, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+func BadRequest(message string) *APIError {
+	return NewAPIError(http.StatusBadRequest, CodeBadRequest, message)
+}
+
+func Unauthorized(message string) *APIError {
+	return NewAPIError(http.StatusUnauthorized, CodeUnauthorized, message)
+}
+
+func Forbidden(message string) *APIError {
+	return NewAPIError(http.StatusForbidden, CodeForbidden, message)
+}
+
+func NotFound(message string) *APIError {
+	return NewAPIError(http.StatusNotFound, CodeNotFound, message)
+}
+
+func Conflict(message string) *APIError {
+	return NewAPIError(http.StatusConflict, CodeConflict, message)
+}
+
+func Gone(message string) *APIError {
+	return NewAPIError(http.StatusGone, CodeGone, message)
+}
+
+func TooManyRequests(message string) *APIError {
+	return NewAPIError(http.StatusTooManyRequests, CodeRateLimited, message)
+}
+
+// Internal is a failure on our side, the cause goes to the logs and the client only gets the message
+func Internal(cause error, message string) *APIError {
+	e := NewAPIError(http.StatusInternalServerError, CodeInternal, message)
+	e.cause = cause
+	return e
+}
+
+// Unavailable is a failure of a service we depend on (OA, captcha, the IdP), worth retrying later
+func Unavailable(cause error, message string) *APIError {
+	e := NewAPIError(http.StatusServiceUnavailable, CodeUnavailable, message)
+	e.cause = cause
+	return e
+}
+
+// InvalidInput is a request which failed binding, the validation failures are listed by field
+func InvalidInput(err error) *APIError {
+	e := NewAPIError(http.StatusBadRequest, CodeInvalidInput, "Invalid request format")
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		fields := make(map[string]string, len(validationErrors))
+		for _, fieldErr := range validationErrors {
+			fields[fieldErr.Field()] = fieldErr.Tag()
+		}
+		e.Details = gin.H{"fields": fields}
+	}
+	e.cause = err
+	return e
+}
+
+// Report the fields by their json name, as the client sent them
+func init() {
+	if engine, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		engine.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				return field.Name
+			}
+			return name
+		})
+	}
+}
+
+// Fail aborts the request with the error, the Errors middleware writes the response
+func Fail(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// Errors writes the envelope for the error of a failed request. Errors which are not an APIError
+// are internal and only get a generic message. The cause is logged by AccessLog with the request.
+func Errors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		var apiErr *APIError
+		if !errors.As(c.Errors.Last().Err, &apiErr) {
+			apiErr = Internal(c.Errors.Last().Err, "Something went wrong, please try again later")
+		}
+		response := *apiErr
+		response.RequestID = connections.RequestID(c.Request.Context())
+		c.JSON(response.Status, response)
+	}
+}
@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"compass/model"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	apiKeyPrefix = "cmp_" // makes leaked keys easy to spot in logs and secret scanners
+	// Writing the last used time on every request would be a write per call, once a minute is enough
+	apiKeyTouchInterval = time.Minute
+)
+
+// NewAPIKey generates a key, returns the key to hand out, its display prefix and the hash to store
+func NewAPIKey() (key string, prefix string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	key = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, key[:len(apiKeyPrefix)+6], hashAPIKey(key), nil
+}
+
+// Keys are long random strings, a plain sha256 is enough and allows the indexed lookup
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// bearerToken extracts the token of an "Authorization: Bearer" header
+func bearerToken(c *gin.Context) (string, bool) {
+	header := c.GetHeader("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// AcceptAPIKeys lets UserAuthenticator take an api key instead of the cookies, use it before UserAuthenticator
+// and only on routes which all check a permission with RequirePermission, the scopes of the key are checked there.
+// Every other route acts with the full rights of the account (profile, sessions, 2FA, ...) which no scope covers.
+func AcceptAPIKeys(c *gin.Context) {
+	c.Set("apiKeysAccepted", true)
+	c.Next()
+}
+
+// apiKeyAuthenticator is the UserAuthenticator for requests carrying an api key,
+// the request acts as the account of the key, limited to the scopes of the key.
+func apiKeyAuthenticator(c *gin.Context, key string) {
+	// Fail closed, before even looking up the key
+	if !c.GetBool("apiKeysAccepted") {
+		Fail(c, Forbidden("API keys can't be used on this route"))
+		return
+	}
+	var apiKey model.APIKey
+	err := DB(c).Where("key_hash = ?", hashAPIKey(key)).First(&apiKey).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		Fail(c, Unauthorized("Invalid API key"))
+		return
+	}
+	if err != nil {
+		Fail(c, Internal(err, "Database error"))
+		return
+	}
+	now := time.Now()
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt)) {
+		Fail(c, Unauthorized("API key expired or revoked"))
+		return
+	}
+
+	var user model.User
+	if err := DB(c).Model(&model.User{}).
+		Select("user_id", "role", "is_verified").
+		Preload("Profile", func(db *gorm.DB) *gorm.DB {
+			return db.Select("user_id", "visibility")
+		}).
+		Where("user_id = ?", apiKey.UserID).
+		First(&user).Error; err != nil {
+		Fail(c, Unauthorized("Invalid API key"))
+		return
+	}
+	if user.Role < model.UserRole {
+		Fail(c, Forbidden("Insufficient permissions"))
+		return
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyTouchInterval {
+		if err := DB(c).Model(&model.APIKey{}).Where("key_id = ?", apiKey.KeyID).
+			Update("last_used_at", now).Error; err != nil {
+			logrus.WithContext(c.Request.Context()).Errorf("Failed to update last use of api key %s: %v", apiKey.KeyID, err)
+		}
+	}
+
+	c.Set("userID", user.UserID)
+	c.Set("userRole", int(user.Role))
+	c.Set("verified", user.IsVerified)
+	c.Set("visibility", user.Profile.Visibility)
+	c.Set("twoFactor", apiKey.MintedWithTwoFactor)
+	c.Set("apiKeyID", apiKey.KeyID)
+	c.Set("apiKeyScopes", apiKey.Scopes)
+	c.Next()
+}
+
+// apiKeyAllows tells if the scopes of the api key used for the request (if any) include the permission
+func apiKeyAllows(c *gin.Context, permission model.Permission) bool {
+	scopes, ok := c.Get("apiKeyScopes")
+	if !ok {
+		return true
+	}
+	return slices.Contains(scopes.([]model.Permission), permission)
+}
+
+// IsAPIKeyRequest tells if the request was authenticated with an api key instead of the cookies
+func IsAPIKeyRequest(c *gin.Context) bool {
+	_, ok := c.Get("apiKeyID")
+	return ok
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"compass/connections"
+	"compass/model"
+	"compass/testutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// TestMain loads the config of the server without connecting to its services, the tests set up the DB they use
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	connections.LoadConfig("../")
+	Init()
+	os.Exit(m.Run())
+}
+
+// useGrants sets up the db with the default grants, like seeded at startup, and drops the cached ones
+func useGrants(t *testing.T) {
+	t.Helper()
+	testutil.UseDB(t)
+	for _, perm := range model.DefaultPermissions {
+		if err := connections.DB.Create(&perm.Def).Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, role := range perm.Roles {
+			if err := connections.DB.Create(&model.RolePermission{Role: role, Permission: perm.Def.Name}).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	resetGrants := func() {
+		permissionCache.Lock()
+		permissionCache.grants = nil
+		permissionCache.Unlock()
+	}
+	resetGrants()
+	t.Cleanup(resetGrants)
+}
+
+// createKey mints a key for a new account of the role
+func createKey(t *testing.T, role model.Role, scopes ...model.Permission) string {
+	t.Helper()
+	user := model.User{Email: uuid.NewString() + "@iitk.ac.in", IsVerified: true, Role: role}
+	if err := connections.DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	key, prefix, hash, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKey := model.APIKey{KeyID: uuid.New(), Name: "bot", Prefix: prefix, KeyHash: hash, UserID: user.UserID, Scopes: scopes, CreatedBy: user.UserID}
+	if err := connections.DB.Create(&apiKey).Error; err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// newAPIKeyTest has a dashboard route taking api keys and a route of the account which does not
+func newAPIKeyTest(t *testing.T) *gin.Engine {
+	t.Helper()
+	useGrants(t)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r := gin.New()
+	r.Use(Errors())
+	admin := r.Group("/admin")
+	admin.Use(AcceptAPIKeys, UserAuthenticator)
+	admin.GET("/logs", RequirePermission(model.DashboardViewPermission), ok)
+	admin.POST("/notice", RequirePermission(model.NoticePublishPermission), ok)
+	admin.POST("/user/:id/role", RequirePermission(model.UserManagePermission), ok)
+	r.GET("/me", UserAuthenticator, ok)
+	// Checks a permission but is not registered for keys
+	r.GET("/impersonate", UserAuthenticator, RequirePermission(model.DashboardViewPermission), ok)
+	return r
+}
+
+func callWithKey(r *gin.Engine, method string, path string, key string) int {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestAPIKeyOnlyOnRegisteredRoutes(t *testing.T) {
+	r := newAPIKeyTest(t)
+	key := createKey(t, model.ModeratorRole, model.DashboardViewPermission)
+
+	if code := callWithKey(r, http.MethodGet, "/admin/logs", key); code != http.StatusOK {
+		t.Errorf("key on a route taking keys status = %d, want 200", code)
+	}
+	for _, path := range []string{"/me", "/impersonate"} {
+		if code := callWithKey(r, http.MethodGet, path, key); code != http.StatusForbidden {
+			t.Errorf("key on %s status = %d, want 403", path, code)
+		}
+	}
+	if code := callWithKey(r, http.MethodGet, "/admin/logs", "cmp_unknown"); code != http.StatusUnauthorized {
+		t.Errorf("unknown key status = %d, want 401", code)
+	}
+}
+
+func TestAPIKeyLimitedToScopes(t *testing.T) {
+	r := newAPIKeyTest(t)
+	// The account may publish notices, the key may not
+	key := createKey(t, model.AdminRole, model.DashboardViewPermission)
+	testutil.SetConfig(t, map[string]any{"twoFactor.requiredForAdmins": false})
+
+	if code := callWithKey(r, http.MethodPost, "/admin/notice", key); code != http.StatusForbidden {
+		t.Errorf("key outside its scopes status = %d, want 403", code)
+	}
+}
+
+// A bot account works with the seeded grants, no edits in the db needed
+func TestBotKeyWithDefaultGrants(t *testing.T) {
+	r := newAPIKeyTest(t)
+	key := createKey(t, model.Bot, model.DashboardViewPermission, model.NoticePublishPermission, model.UserManagePermission)
+
+	if code := callWithKey(r, http.MethodGet, "/admin/logs", key); code != http.StatusOK {
+		t.Errorf("bot key on the logs status = %d, want 200", code)
+	}
+	if code := callWithKey(r, http.MethodPost, "/admin/notice", key); code != http.StatusOK {
+		t.Errorf("bot key publishing a notice status = %d, want 200", code)
+	}
+	// Never granted to bots, whatever the key says
+	if code := callWithKey(r, http.MethodPost, "/admin/user/1/role", key); code != http.StatusForbidden {
+		t.Errorf("bot key changing a role status = %d, want 403", code)
+	}
+}
@@ -1,24 +1,21 @@
 package middleware
 
 import (
-	"compass/connections"
 	"compass/model"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/google/uuid"
 	"github.com/spf13/viper"
 	"gorm.io/gorm"
 )
 
 var authConfig = AuthConfig{
-	JWTSecretKey:       viper.GetString("jwt.secret"),
 	TokenExpiration:    5 * time.Minute,
 	RefreshTokenExpiry: 24 * 7 * time.Hour, // 7 days
-	CookieDomain:       viper.GetString("domain"),
-	CookieSecure:       false, // Set to false in development
+	// CookieDomain is set by Init from domain
+	CookieSecure: false, // Set to false in development
 	// TODO: MUST set to true in production
 	// The Secure attribute is a crucial cookie configuration setting that instructs a web browser to send a cookie only over an encrypted HTTPS connection
 	CookieHTTPOnly: true, // Prevent XSS
@@ -27,6 +24,11 @@ var authConfig = AuthConfig{
 
 // TODO: Extract the basic token extraction and verification out and keep just the user part
 func UserAuthenticator(c *gin.Context) {
+	// Bots and service accounts send an api key instead of the cookies
+	if key, ok := bearerToken(c); ok {
+		apiKeyAuthenticator(c, key)
+		return
+	}
 	// Check for cookie
 	tokenString, err := c.Cookie("auth_token")
 	if err != nil {
@@ -35,9 +37,7 @@ func UserAuthenticator(c *gin.Context) {
 		return
 	}
 	// extract token
-	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(authConfig.JWTSecretKey), nil
-	})
+	token, err := keys.parse(tokenString, &JWTClaims{}, AccessToken)
 	if err != nil || !token.Valid {
 		tryRefresh(c)
 		return
@@ -45,7 +45,7 @@ func UserAuthenticator(c *gin.Context) {
 	// Type conversion to *JWTClaims
 	claims, ok := token.Claims.(*JWTClaims)
 	if !ok {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		Fail(c, Unauthorized("Invalid token claims"))
 		return
 	}
 	// Set the role here
@@ -54,10 +54,17 @@ func UserAuthenticator(c *gin.Context) {
 	c.Set("userRole", claims.Role)
 	c.Set("verified", claims.Verified)
 	c.Set("visibility", claims.Visibility)
+	c.Set("twoFactor", claims.TwoFactor)
+	if claims.Impersonator != nil {
+		c.Set("impersonator", *claims.Impersonator)
+		if !impersonationAllows(c, claims) {
+			return
+		}
+	}
 
 	// Verify the user power
 	if role := c.GetInt("userRole"); role < int(model.UserRole) {
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		Fail(c, Forbidden("Insufficient permissions"))
 		return
 	}
 	c.Next()
@@ -65,38 +72,36 @@ func UserAuthenticator(c *gin.Context) {
 func tryRefresh(c *gin.Context) {
 	refreshToken, err := c.Cookie("refresh_token")
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		Fail(c, Unauthorized("Unauthorized"))
 		return
 	}
-	token, err := jwt.ParseWithClaims(
-		refreshToken,
-		&JWTClaimsRefresh{},
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(authConfig.JWTSecretKey), nil
-		},
-	)
-
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+	// Every refresh rotates the refresh token as well
+	newRefreshToken, stored, err := rotateRefreshToken(c.Request.Context(), refreshToken)
+	switch {
+	case err == nil:
+		SetRefreshCookie(c, newRefreshToken)
+	case errors.Is(err, errRefreshRaced):
+		// A parallel request already rotated it, the browser gets the new cookie from that response
+	case errors.Is(err, errRefreshReused):
+		ClearAuthCookie(c)
+		Fail(c, Unauthorized("Session revoked, please login again").WithCode(CodeSessionExpired))
 		return
-	}
-
-	claims, ok := token.Claims.(*JWTClaimsRefresh)
-	if !ok || !token.Valid {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+	case errors.Is(err, errRefreshInvalid):
+		ClearAuthCookie(c)
+		Fail(c, Unauthorized("Invalid token").WithCode(CodeSessionExpired))
 		return
-	}
-
-	userID, err := uuid.Parse(claims.UserID)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+	default:
+		Fail(c, Internal(err, "Database error"))
 		return
 	}
 
+	userID := stored.UserID
+	twoFactor := sessionTwoFactor(c.Request.Context(), stored.FamilyID)
+
 	// Fetch user details from db
 
 	var modelUser model.User
-	result := connections.DB.
+	result := DB(c).
 		Model(&model.User{}).
 		Select("role", "is_verified").
 		Preload("Profile", func(db *gorm.DB) *gorm.DB {
@@ -105,7 +110,7 @@ func tryRefresh(c *gin.Context) {
 		Where("user_id = ?", userID).
 		First(&modelUser)
 	if result.Error != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		Fail(c, Internal(result.Error, "Database error"))
 		return
 	}
 	// Ideally fetch role + verified from DB
@@ -114,9 +119,9 @@ func tryRefresh(c *gin.Context) {
 	visibility := modelUser.Profile.Visibility
 
 	//geneate new access token
-	newAccessToken, err := GenerateAccessToken(userID)
+	newAccessToken, err := GenerateAccessToken(c.Request.Context(), userID, twoFactor)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate access token"})
+		Fail(c, Internal(err, "Failed to generate access token"))
 		return
 	}
 	SetAuthCookie(c, newAccessToken)
@@ -126,17 +131,19 @@ func tryRefresh(c *gin.Context) {
 	c.Set("userRole", role)
 	c.Set("verified", verified)
 	c.Set("visibility", visibility)
+	c.Set("twoFactor", twoFactor)
 
 	c.Next()
 }
 
-func AdminAuthenticator(c *gin.Context) {
-	// verify the role
-	if role := c.GetInt("userRole"); role < int(model.AdminRole) {
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
-		return
+// twoFactorSatisfied checks the 2FA claim, when twoFactor.requiredForAdmins is on
+// the tokens of roles >= AdminRole must come from a login that passed the second factor.
+// An api key passes when it was minted by a login which passed it (APIKey.MintedWithTwoFactor).
+func twoFactorSatisfied(c *gin.Context) bool {
+	if !viper.GetBool("twoFactor.requiredForAdmins") || c.GetInt("userRole") < int(model.AdminRole) {
+		return true
 	}
-	c.Next()
+	return c.GetBool("twoFactor")
 }
 
 // But once the user verifies the email, the cookie will remain same hence will need to login again
@@ -147,11 +154,11 @@ func EmailVerified(c *gin.Context) {
 
 	// TODO: better way for this check, as its in every handler request.
 	if !exist {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		Fail(c, Unauthorized("Unauthorized"))
 		return
 	}
 	if !verified.(bool) {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Please verify your email to continue"})
+		Fail(c, Unauthorized("Please verify your email to continue"))
 		return
 	}
 	// TODO: implement the refresh the token, can remove this then, as the token will not have the verification update
@@ -165,19 +172,19 @@ func CheckVisibility(c *gin.Context) {
 	// TODO: better way for this check, as its in every handler request.
 	visibility, exists := c.Get("visibility")
 	if !exists {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		Fail(c, Unauthorized("Unauthorized"))
 		return
 	}
 
 	if isVisible, ok := visibility.(bool); ok {
 		if !isVisible {
 			c.Redirect(http.StatusFound, "/profile")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized, Please make your profile visible/public to view others"})
+			Fail(c, Unauthorized("Unauthorized, Please make your profile visible/public to view others"))
 			return
 		}
 	} else {
 		// if data type is wrong (not bool)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		Fail(c, Internal(errors.New("visibility is not a bool"), "Internal server error"))
 		return
 	}
 
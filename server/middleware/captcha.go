@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// CaptchaResult is the common part of the siteverify responses of all the providers
+type CaptchaResult struct {
+	Success    bool     `json:"success"`
+	Score      *float64 `json:"score"`    // reCAPTCHA v3 and hCaptcha enterprise only
+	Action     string   `json:"action"`   // action the widget was rendered for, empty if the provider has none
+	Hostname   string   `json:"hostname"` // site where the challenge was solved
+	ErrorCodes []string `json:"error-codes"`
+}
+
+// CaptchaVerifier checks a captcha token solved by the client
+type CaptchaVerifier interface {
+	Verify(ctx context.Context, token string, remoteIP string) (CaptchaResult, error)
+}
+
+// siteVerifyCaptcha works for reCAPTCHA, hCaptcha and Turnstile, they all share the same siteverify api
+type siteVerifyCaptcha struct {
+	endpoint string
+	secret   string
+	client   *http.Client
+}
+
+func (s siteVerifyCaptcha) Verify(ctx context.Context, token string, remoteIP string) (CaptchaResult, error) {
+	var result CaptchaResult
+	form := url.Values{"secret": {s.secret}, "response": {token}, "remoteip": {remoteIP}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return result, fmt.Errorf("siteverify returned %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	return result, err
+}
+
+// noopCaptcha accepts everything, for local development
+type noopCaptcha struct{}
+
+func (noopCaptcha) Verify(context.Context, string, string) (CaptchaResult, error) {
+	return CaptchaResult{Success: true}, nil
+}
+
+var captchaEndpoints = map[string]string{
+	"recaptcha": "https://www.google.com/recaptcha/api/siteverify",
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+	"turnstile": "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+// captcha is set up by Init
+var captcha CaptchaVerifier
+
+// newCaptchaVerifier picks the provider from captcha.provider
+func newCaptchaVerifier() CaptchaVerifier {
+	provider := viper.GetString("captcha.provider")
+	endpoint, ok := captchaEndpoints[provider]
+	if !ok {
+		if provider != "none" {
+			logrus.Fatalf("Unknown captcha provider %q", provider)
+		}
+		// The public write endpoints would be open to bots, refuse to start rather than run like that
+		if viper.GetString("env") == "prod" {
+			logrus.Fatal("Captcha can't be disabled (captcha.provider: none) in production")
+		}
+		return noopCaptcha{}
+	}
+	if viper.GetString("captcha.secret") == "" && viper.GetString("recaptcha.key") != "" {
+		logrus.Fatal("recaptcha.key was renamed to captcha.secret, move it in secret.yml")
+	}
+	return siteVerifyCaptcha{
+		endpoint: endpoint,
+		secret:   viper.GetString("captcha.secret"),
+		client:   &http.Client{Timeout: 5 * time.Second, Transport: otelhttp.NewTransport(http.DefaultTransport)},
+	}
+}
+
+// checkCaptchaResult validates the parts of the response the providers leave to us
+func checkCaptchaResult(result CaptchaResult, action string) error {
+	if !result.Success {
+		return fmt.Errorf("rejected by the provider %v", result.ErrorCodes)
+	}
+	if result.Score != nil && *result.Score < viper.GetFloat64("captcha.minScore") {
+		return fmt.Errorf("score %.2f too low", *result.Score)
+	}
+	// A token solved for another form (or another site) must not be replayed here
+	if result.Action != "" && result.Action != action {
+		return fmt.Errorf("token was solved for action %q", result.Action)
+	}
+	if hostnames := viper.GetStringSlice("captcha.hostnames"); result.Hostname != "" && len(hostnames) > 0 && !slices.Contains(hostnames, result.Hostname) {
+		return fmt.Errorf("token was solved on %q", result.Hostname)
+	}
+	return nil
+}
+
+// captchaToken reads the token from the X-Captcha-Token header or the "token" field of the json body,
+// the body is put back for the handler.
+func captchaToken(c *gin.Context) string {
+	if token := c.GetHeader("X-Captcha-Token"); token != "" {
+		return token
+	}
+	if c.Request.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	c.Request.Body.Close()
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	var payload struct {
+		Token string `json:"token"`
+	}
+	if json.Unmarshal(body, &payload) != nil {
+		return ""
+	}
+	return payload.Token
+}
+
+// Captcha protects public write endpoints (signup, login, ...), action is the name the widget was rendered with
+func Captcha(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := captcha.(noopCaptcha); ok {
+			c.Next()
+			return
+		}
+		token := captchaToken(c)
+		if token == "" {
+			Fail(c, Forbidden("Failed captcha verification").WithCode(CodeCaptchaFailed))
+			return
+		}
+		result, err := captcha.Verify(c.Request.Context(), token, c.ClientIP())
+		if err != nil {
+			logrus.WithContext(c.Request.Context()).Error("Captcha verification failed: ", err)
+			Fail(c, Unavailable(err, "Captcha verification is unavailable, please try again"))
+			return
+		}
+		if err := checkCaptchaResult(result, action); err != nil {
+			logrus.WithContext(c.Request.Context()).Warnf("Captcha rejected for %s from %s: %v", action, c.ClientIP(), err)
+			Fail(c, Forbidden("Failed captcha verification").WithCode(CodeCaptchaFailed))
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Double submit token, the cookie is readable by the frontend which echoes it in the header.
+// A cross site page can make the browser send the cookie but can't read it to set the header.
+const (
+	csrfCookie = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+)
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// SetCSRFCookie issues a fresh token, called on login as well so a token planted before it is of no use
+func SetCSRFCookie(c *gin.Context) string {
+	token, err := newCSRFToken()
+	if err != nil {
+		return ""
+	}
+	c.SetSameSite(authConfig.SameSiteMode)
+	c.SetCookie(
+		csrfCookie,
+		token,
+		int(authConfig.RefreshTokenExpiry.Seconds()),
+		"/",
+		authConfig.CookieDomain,
+		authConfig.CookieSecure,
+		false, // the frontend has to read it
+	)
+	c.Set("csrfToken", token)
+	return token
+}
+
+// CSRF issues the token cookie when missing and checks the X-CSRF-Token header on unsafe methods
+func CSRF() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cookie, err := c.Cookie(csrfCookie)
+		if err != nil || cookie == "" {
+			cookie = ""
+			SetCSRFCookie(c)
+		} else {
+			c.Set("csrfToken", cookie)
+		}
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		// An api key is attached by the caller itself, the browser never adds it on its own
+		if _, ok := bearerToken(c); ok {
+			c.Next()
+			return
+		}
+		header := c.GetHeader(csrfHeader)
+		if cookie == "" || header == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) != 1 {
+			Fail(c, Forbidden("Invalid CSRF token, please refresh the page and try again").WithCode(CodeInvalidCSRF))
+			return
+		}
+		c.Next()
+	}
+}
+
+// CSRFTokenHandler gives the token of the browser, for clients which can't read the cookie
+func CSRFTokenHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"csrfToken": c.GetString("csrfToken")})
+}
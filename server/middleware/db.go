@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"compass/connections"
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// DB is connections.DB bound to the context of the request, so the queries show up in its trace.
+// Handlers pass the gin context, helpers called by them the context.Context they were given.
+func DB(ctx context.Context) *gorm.DB {
+	// The gin context does not carry the request context unless ContextWithFallback is set
+	if c, ok := ctx.(*gin.Context); ok {
+		ctx = c.Request.Context()
+	}
+	return connections.DB.WithContext(ctx)
+}
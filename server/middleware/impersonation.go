@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"compass/model"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// StartImpersonation replaces the access cookie of the admin with a short lived token of the target user.
+// The refresh cookie stays the admin's, so once the token expires or is dropped the admin is back as themselves.
+// The token carries the id of the model.Impersonation record as its jti.
+func StartImpersonation(c *gin.Context, impersonation model.Impersonation, expiry time.Duration) error {
+	claims, err := accessClaims(c.Request.Context(), impersonation.UserID, c.GetBool("twoFactor"), expiry)
+	if err != nil {
+		return err
+	}
+	claims.ID = impersonation.ID.String()
+	claims.Impersonator = &impersonation.AdminID
+	claims.Elevated = impersonation.Elevated
+	token, err := keys.sign(AccessToken, claims)
+	if err != nil {
+		return err
+	}
+	c.SetSameSite(authConfig.SameSiteMode)
+	c.SetCookie(
+		"auth_token",
+		token,
+		int(expiry.Seconds()),
+		"/",
+		authConfig.CookieDomain,
+		authConfig.CookieSecure,
+		authConfig.CookieHTTPOnly,
+	)
+	return nil
+}
+
+// ImpersonationClaims reads the access cookie, ok only when it is a valid impersonation token
+func ImpersonationClaims(c *gin.Context) (JWTClaims, bool) {
+	var claims JWTClaims
+	tokenString, err := c.Cookie("auth_token")
+	if err != nil {
+		return claims, false
+	}
+	if err := ParseClaims(AccessToken, tokenString, &claims); err != nil || claims.Impersonator == nil {
+		return claims, false
+	}
+	return claims, true
+}
+
+// EndImpersonation drops the impersonation token, the next request refreshes into the admin's own session
+func EndImpersonation(c *gin.Context) {
+	c.SetSameSite(authConfig.SameSiteMode)
+	c.SetCookie(
+		"auth_token",
+		"",
+		-1,
+		"/",
+		authConfig.CookieDomain,
+		authConfig.CookieSecure,
+		authConfig.CookieHTTPOnly,
+	)
+}
+
+// impersonationAllows keeps impersonation sessions read only, elevated ones may write but every write is logged
+func impersonationAllows(c *gin.Context, claims *JWTClaims) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	if !claims.Elevated {
+		Fail(c, Forbidden("Read only impersonation session, stop impersonating to make changes").WithCode(CodeImpersonating))
+		return false
+	}
+	entry := model.NewLog(
+		"Impersonated action",
+		fmt.Sprintf("Admin %s as user %s: %s %s", claims.Impersonator, claims.UserID, c.Request.Method, c.Request.URL.Path),
+		model.AdminActor,
+	)
+	if err := DB(c).Create(&entry).Error; err != nil {
+		logrus.WithContext(c.Request.Context()).Errorf("Failed to log impersonated action of admin %s: %v", claims.Impersonator, err)
+	}
+	return true
+}
+
+// NoImpersonation guards the account security routes (password, email, 2FA, ...), even an elevated
+// impersonation must not be able to take over the account it is looking at.
+func NoImpersonation(c *gin.Context) {
+	if _, ok := c.Get("impersonator"); ok {
+		Fail(c, Forbidden("Not allowed while impersonating").WithCode(CodeImpersonating))
+		return
+	}
+	c.Next()
+}
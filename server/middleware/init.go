@@ -0,0 +1,12 @@
+package middleware
+
+import "github.com/spf13/viper"
+
+// Init sets up what the middlewares read from the config (cookies, jwt keys, captcha and the rate limit store).
+// main calls it after connections.Init, a missing or broken setting stops the server right at the start.
+func Init() {
+	authConfig.CookieDomain = viper.GetString("domain")
+	keys = mustLoadKeyring()
+	captcha = newCaptchaVerifier()
+	limiterStore = newRateLimitStore()
+}
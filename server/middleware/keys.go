@@ -0,0 +1,236 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// verificationKey is a public key accepted on tokens carrying its kid
+type verificationKey struct {
+	method jwt.SigningMethod
+	public crypto.PublicKey
+}
+
+// keyring holds the key used for signing and every key still accepted for verification.
+// With no keys configured it falls back to HS256 with jwt.secret, the old behaviour.
+type keyring struct {
+	signingKID string
+	signer     crypto.Signer // nil when this process only verifies
+	keys       map[string]verificationKey
+	hmacSecret []byte // accepted on tokens without a kid, nil once the migration is over
+}
+
+// jwtKeyConfig is an entry of jwt.keys, the file is a PEM private key (can sign and verify)
+// or a PEM public key (verify only, like a retired key or a key of another instance)
+type jwtKeyConfig struct {
+	KID  string `mapstructure:"kid"`
+	File string `mapstructure:"file"`
+}
+
+// keys are loaded from jwt.keys by Init
+var keys *keyring
+
+func mustLoadKeyring() *keyring {
+	ring, err := loadKeyring()
+	if err != nil {
+		logrus.Fatal("Failed to load the jwt keys: ", err)
+	}
+	return ring
+}
+
+func loadKeyring() (*keyring, error) {
+	var configs []jwtKeyConfig
+	if err := viper.UnmarshalKey("jwt.keys", &configs); err != nil {
+		return nil, err
+	}
+	if len(configs) == 0 {
+		logrus.Warn("No jwt.keys configured, signing tokens with the shared HS256 secret")
+		return &keyring{hmacSecret: []byte(viper.GetString("jwt.secret"))}, nil
+	}
+
+	ring := &keyring{keys: make(map[string]verificationKey)}
+	signingKID := viper.GetString("jwt.signingKey")
+	for _, cfg := range configs {
+		if cfg.KID == "" {
+			return nil, fmt.Errorf("key %s has no kid", cfg.File)
+		}
+		if _, dup := ring.keys[cfg.KID]; dup {
+			return nil, fmt.Errorf("duplicate kid %s", cfg.KID)
+		}
+		signer, public, err := readKeyFile(cfg.File)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", cfg.KID, err)
+		}
+		method, err := methodFor(public)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", cfg.KID, err)
+		}
+		ring.keys[cfg.KID] = verificationKey{method: method, public: public}
+		if cfg.KID == signingKID {
+			if signer == nil {
+				return nil, fmt.Errorf("signing key %s is a public key", cfg.KID)
+			}
+			ring.signingKID, ring.signer = cfg.KID, signer
+		}
+	}
+	if signingKID != "" && ring.signer == nil {
+		return nil, fmt.Errorf("signing key %s is not in jwt.keys", signingKID)
+	}
+	// Tokens signed before the switch stay valid until they expire
+	if viper.GetBool("jwt.acceptLegacySecret") {
+		ring.hmacSecret = []byte(viper.GetString("jwt.secret"))
+	}
+	return ring, nil
+}
+
+// readKeyFile parses a PEM file holding a PKCS8/PKCS1 private key or a PKIX public key
+func readKeyFile(path string) (crypto.Signer, crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, errors.New("not a PEM file")
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, nil, errors.New("unsupported private key")
+		}
+		return signer, signer.Public(), nil
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, key.Public(), nil
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		return nil, key, err
+	}
+	return nil, nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+// methodFor picks the algorithm from the key type, RSA keys sign RS256 and Ed25519 keys EdDSA
+func methodFor(public crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", public)
+}
+
+// sign signs the claims with the current signing key, its kid and the token type go in the header
+func (k *keyring) sign(typ TokenType, claims jwt.Claims) (string, error) {
+	if k.signer == nil {
+		if k.keys == nil {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+			token.Header["typ"] = string(typ)
+			return token.SignedString(k.hmacSecret)
+		}
+		return "", errors.New("no jwt signing key configured")
+	}
+	token := jwt.NewWithClaims(k.keys[k.signingKID].method, claims)
+	token.Header["kid"] = k.signingKID
+	token.Header["typ"] = string(typ)
+	return token.SignedString(k.signer)
+}
+
+// keyFunc finds the key of the token by its kid, the algorithm must be the one of the key
+// so a public key can never be used as an HMAC secret.
+func (k *keyring) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if k.hmacSecret == nil || token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("token has no kid")
+		}
+		return k.hmacSecret, nil
+	}
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %s", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+// parse verifies the token, checks it is of one of the types and fills the claims
+func (k *keyring) parse(tokenString string, claims jwt.Claims, types ...TokenType) (*jwt.Token, error) {
+	token, err := jwt.ParseWithClaims(tokenString, claims, k.keyFunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}))
+	if err != nil {
+		return token, err
+	}
+	typ, _ := token.Header["typ"].(string)
+	if !slices.Contains(types, TokenType(typ)) {
+		return token, fmt.Errorf("%w: token type %q, want %v", jwt.ErrTokenInvalidClaims, typ, types)
+	}
+	return token, nil
+}
+
+// jwk is the RFC 7517 form of a public key
+type jwk struct {
+	KID string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA exponent
+	Crv string `json:"crv,omitempty"` // OKP curve
+	X   string `json:"x,omitempty"`   // OKP public key
+}
+
+func (k *keyring) jwks() []jwk {
+	set := make([]jwk, 0, len(k.keys))
+	for kid, key := range k.keys {
+		entry := jwk{KID: kid, Alg: key.method.Alg(), Use: "sig"}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			entry.Kty = "RSA"
+			entry.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			entry.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			entry.Kty = "OKP"
+			entry.Crv = "Ed25519"
+			entry.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set = append(set, entry)
+	}
+	return set
+}
+
+// JWKSHandler publishes the verification keys, other services can verify compass tokens with them
+// without holding the signing key. Empty while the HS256 secret is in use.
+func JWKSHandler(c *gin.Context) {
+	// Short cache, a newly added key has to be picked up before it starts signing
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": keys.jwks()})
+}
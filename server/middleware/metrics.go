@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "compass",
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by server, route and status.",
+	}, []string{"server", "method", "route", "status"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "compass",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Time to serve a request by server and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"server", "method", "route"})
+	httpInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "compass",
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "Requests being served by server.",
+	}, []string{"server"})
+)
+
+// Metrics records the requests of a server, labelled by the route pattern (/api/maps/location/:id)
+// rather than the path so the ids don't blow up the series. The collectors are served on ports.metrics.
+func Metrics(server string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		inFlight := httpInFlight.WithLabelValues(server)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		httpRequests.WithLabelValues(server, method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(server, method, route).Observe(time.Since(start).Seconds())
+	}
+}
@@ -14,6 +14,10 @@ type JWTClaims struct {
 	Role     int       `json:"role"`
 	Verified bool      `json:"verified"`
 	Visibility bool    `json:"visibility"`
+	TwoFactor  bool    `json:"mfa"` // session passed the second factor at login
+	// Set when an admin is viewing as this user, the admin's id. Such tokens are read only unless Elevated
+	Impersonator *uuid.UUID `json:"impersonator,omitempty"`
+	Elevated     bool       `json:"elevated,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -25,7 +29,6 @@ type JWTClaimsRefresh struct {
 
 // AuthConfig holds authentication configuration
 type AuthConfig struct {
-	JWTSecretKey    string
 	TokenExpiration time.Duration
 	RefreshTokenExpiry time.Duration
 	CookieDomain    string
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"compass/model"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Grants change rarely, they are read from the db at most once per permissionCacheTTL
+const permissionCacheTTL = time.Minute
+
+var permissionCache = struct {
+	sync.RWMutex
+	grants   map[model.Role]map[model.Permission]bool
+	loadedAt time.Time
+}{}
+
+func loadGrants(ctx context.Context) (map[model.Role]map[model.Permission]bool, error) {
+	permissionCache.RLock()
+	if permissionCache.grants != nil && time.Since(permissionCache.loadedAt) < permissionCacheTTL {
+		defer permissionCache.RUnlock()
+		return permissionCache.grants, nil
+	}
+	permissionCache.RUnlock()
+
+	var rows []model.RolePermission
+	if err := DB(ctx).Omit("Def").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	grants := make(map[model.Role]map[model.Permission]bool)
+	for _, row := range rows {
+		if grants[row.Role] == nil {
+			grants[row.Role] = make(map[model.Permission]bool)
+		}
+		grants[row.Role][row.Permission] = true
+	}
+	permissionCache.Lock()
+	permissionCache.grants, permissionCache.loadedAt = grants, time.Now()
+	permissionCache.Unlock()
+	return grants, nil
+}
+
+// HasPermission tells if the role is granted the permission
+func HasPermission(ctx context.Context, role model.Role, permission model.Permission) (bool, error) {
+	grants, err := loadGrants(ctx)
+	if err != nil {
+		return false, err
+	}
+	return grants[role][permission], nil
+}
+
+// RequirePermission lets the request through only if the role of the user holds all the permissions,
+// and for api key requests only if the key is scoped to them as well. Use it after UserAuthenticator.
+func RequirePermission(permissions ...model.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := model.Role(c.GetInt("userRole"))
+		for _, permission := range permissions {
+			ok, err := HasPermission(c.Request.Context(), role, permission)
+			if err != nil {
+				logrus.WithContext(c.Request.Context()).Error("Failed to load permissions: ", err)
+				Fail(c, Internal(err, "Database error"))
+				return
+			}
+			if !ok || !apiKeyAllows(c, permission) {
+				Fail(c, Forbidden("Insufficient permissions"))
+				return
+			}
+		}
+		if !twoFactorSatisfied(c) {
+			Fail(c, Forbidden("Two factor authentication is required for admin access").WithCode(CodeTwoFactorRequired))
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,217 @@
+package middleware
+
+import (
+	"compass/connections"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "compass",
+	Subsystem: "ratelimit",
+	Name:      "rejected_total",
+	Help:      "Requests rejected by the rate limit by group.",
+}, []string{"group"})
+
+// bucketLimit of a route group, read from rateLimit.groups in config
+type bucketLimit struct {
+	Capacity int     // requests allowed in a burst
+	Refill   float64 // tokens added back per second
+}
+
+// window is the time an empty bucket takes to fill up again
+func (l bucketLimit) window() time.Duration {
+	return time.Duration(float64(l.Capacity) / l.Refill * float64(time.Second))
+}
+
+// bucketState is what is left in the bucket after taking a token
+type bucketState struct {
+	Allowed bool
+	Tokens  float64
+}
+
+// rateLimitStore keeps the buckets, take refills the bucket of the key for the time passed and takes a token from it
+type rateLimitStore interface {
+	take(ctx context.Context, key string, limit bucketLimit) (bucketState, error)
+}
+
+// memoryStore keeps the buckets in the process, each replica then has its own limits
+type memoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // when the bucket is full again, it can be forgotten after that
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{buckets: make(map[string]*memoryBucket), lastSweep: time.Now()}
+}
+
+func (m *memoryStore) take(_ context.Context, key string, limit bucketLimit) (bucketState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	// A full bucket is same as no bucket, drop them once in a while so the map doesn't grow with every ip
+	if now.Sub(m.lastSweep) > time.Minute {
+		for k, bucket := range m.buckets {
+			if now.After(bucket.full) {
+				delete(m.buckets, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	bucket, ok := m.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: float64(limit.Capacity), updated: now}
+		m.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(float64(limit.Capacity), bucket.tokens+now.Sub(bucket.updated).Seconds()*limit.Refill)
+	bucket.updated = now
+	state := bucketState{Tokens: bucket.tokens}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		state = bucketState{Allowed: true, Tokens: bucket.tokens}
+	}
+	bucket.full = now.Add(time.Duration((float64(limit.Capacity) - bucket.tokens) / limit.Refill * float64(time.Second)))
+	return state, nil
+}
+
+// The refill and take in one script, so parallel requests of the replicas can't both spend the last token.
+// The clock of redis is used, the replicas may not agree on the time.
+var takeTokenScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local refill = tonumber(ARGV[2]) / 1000
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(bucket[1]) or capacity
+local updated = tonumber(bucket[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - updated) * refill)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) / refill) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// redisStore shares the buckets across the replicas
+type redisStore struct {
+	client *redis.Client
+}
+
+func (r redisStore) take(ctx context.Context, key string, limit bucketLimit) (bucketState, error) {
+	reply, err := takeTokenScript.Run(ctx, r.client, []string{key}, limit.Capacity, limit.Refill).Slice()
+	if err != nil {
+		return bucketState{}, err
+	}
+	if len(reply) != 2 {
+		return bucketState{}, fmt.Errorf("unexpected rate limit script reply %v", reply)
+	}
+	allowed, _ := reply[0].(int64)
+	tokensReply, _ := reply[1].(string)
+	tokens, err := strconv.ParseFloat(tokensReply, 64)
+	if err != nil {
+		return bucketState{}, err
+	}
+	return bucketState{Allowed: allowed == 1, Tokens: tokens}, nil
+}
+
+// limiterStore is set up by Init, after connections.Init so redis is there
+var limiterStore rateLimitStore
+
+// newRateLimitStore picks the backend from rateLimit.backend
+func newRateLimitStore() rateLimitStore {
+	switch backend := viper.GetString("rateLimit.backend"); backend {
+	case "", "memory":
+		return newMemoryStore()
+	case "redis":
+		if connections.Redis == nil {
+			logrus.Fatal("Rate limit backend is redis but redis.host is not set")
+		}
+		return redisStore{client: connections.Redis}
+	default:
+		logrus.Fatalf("Unknown rate limit backend %q", backend)
+		return nil
+	}
+}
+
+func groupLimit(group string) bucketLimit {
+	key := "rateLimit.groups." + group
+	if !viper.IsSet(key) {
+		logrus.Fatalf("Rate limit group %q is not configured in %s", group, key)
+	}
+	limit := bucketLimit{
+		Capacity: viper.GetInt(key + ".capacity"),
+		Refill:   viper.GetFloat64(key+".refill") / 60,
+	}
+	if limit.Capacity < 1 || limit.Refill <= 0 {
+		logrus.Fatalf("Rate limit group %q needs a capacity and a refill above zero", group)
+	}
+	return limit
+}
+
+// rateLimitClient is the user for logged in requests (put it after the authenticator), else the ip
+func rateLimitClient(c *gin.Context) string {
+	if userID, ok := c.Get("userID"); ok {
+		if id, ok := userID.(uuid.UUID); ok {
+			return "user:" + id.String()
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// RateLimit limits the requests of every client to the routes of the group with a token bucket,
+// the limits are in rateLimit.groups. The state is sent in the RateLimit-* headers (IETF draft),
+// a rejected request gets a 429 with Retry-After.
+func RateLimit(group string) gin.HandlerFunc {
+	if !viper.GetBool("rateLimit.enabled") {
+		return func(c *gin.Context) { c.Next() }
+	}
+	limit := groupLimit(group)
+	policy := fmt.Sprintf("%d;w=%d", limit.Capacity, int(math.Ceil(limit.window().Seconds())))
+
+	return func(c *gin.Context) {
+		key := "ratelimit:" + group + ":" + rateLimitClient(c)
+		state, err := limiterStore.take(c.Request.Context(), key, limit)
+		if err != nil {
+			// Better to let the requests through than to take the site down with the store
+			logrus.WithContext(c.Request.Context()).Error("Rate limit store failed: ", err)
+			c.Next()
+			return
+		}
+		untilFull := (float64(limit.Capacity) - state.Tokens) / limit.Refill
+		c.Header("RateLimit-Policy", policy)
+		c.Header("RateLimit-Limit", strconv.Itoa(limit.Capacity))
+		c.Header("RateLimit-Remaining", strconv.Itoa(int(math.Floor(state.Tokens))))
+		c.Header("RateLimit-Reset", strconv.Itoa(int(math.Ceil(untilFull))))
+		if !state.Allowed {
+			retryAfter := (1 - state.Tokens) / limit.Refill
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter))))
+			rateLimited.WithLabelValues(group).Inc()
+			Fail(c, TooManyRequests("Too many requests, please slow down"))
+			return
+		}
+		c.Next()
+	}
+}
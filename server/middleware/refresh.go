@@ -0,0 +1,230 @@
+package middleware
+
+import (
+	"compass/model"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Browsers fire parallel requests once the access token expires, all of them carry the same refresh token.
+// Within this window a rotated token is still accepted (without rotating again) instead of being treated as stolen.
+const refreshReuseGrace = 30 * time.Second
+
+var (
+	errRefreshInvalid = errors.New("invalid refresh token")
+	errRefreshReused  = errors.New("rotated refresh token reused")
+	errRefreshRaced   = errors.New("refresh token rotated by a parallel request")
+)
+
+// Only the hash goes to the db, a leaked table can't be used to login
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueRefreshToken signs a new refresh token in the given family and stores it, returns the token and its id
+func issueRefreshToken(db *gorm.DB, userID uuid.UUID, familyID uuid.UUID) (string, uuid.UUID, error) {
+	now := time.Now()
+	tokenID := uuid.New()
+	claims := JWTClaimsRefresh{
+		UserID: userID.String(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID.String(),
+			Subject:   userID.String(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(authConfig.RefreshTokenExpiry)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    "pclub",
+		},
+	}
+	signed, err := keys.sign(RefreshToken, claims)
+	if err != nil {
+		return "", uuid.Nil, err
+	}
+	record := model.RefreshToken{
+		TokenID:   tokenID,
+		FamilyID:  familyID,
+		UserID:    userID,
+		TokenHash: hashRefreshToken(signed),
+		ExpiresAt: now.Add(authConfig.RefreshTokenExpiry),
+	}
+	if err := db.Create(&record).Error; err != nil {
+		return "", uuid.Nil, err
+	}
+	return signed, tokenID, nil
+}
+
+// findRefreshToken verifies the jwt and matches it with the stored record
+func findRefreshToken(ctx context.Context, tokenString string) (model.RefreshToken, error) {
+	var stored model.RefreshToken
+	token, err := keys.parse(tokenString, &JWTClaimsRefresh{}, RefreshToken, legacyRefreshToken)
+	if err != nil || !token.Valid {
+		return stored, errRefreshInvalid
+	}
+	claims, ok := token.Claims.(*JWTClaimsRefresh)
+	if !ok {
+		return stored, errRefreshInvalid
+	}
+	tokenID, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return stored, errRefreshInvalid
+	}
+	if err := DB(ctx).First(&stored, "token_id = ?", tokenID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return stored, errRefreshInvalid
+		}
+		return stored, err
+	}
+	if stored.TokenHash != hashRefreshToken(tokenString) {
+		return stored, errRefreshInvalid
+	}
+	return stored, nil
+}
+
+// rotateRefreshToken revokes the presented token and issues its replacement in the same family.
+// Presenting an already rotated token means two parties hold it, so the whole family gets revoked.
+// It returns the new token and the record of the presented one.
+func rotateRefreshToken(ctx context.Context, tokenString string) (string, model.RefreshToken, error) {
+	stored, err := findRefreshToken(ctx, tokenString)
+	if err != nil {
+		return "", stored, err
+	}
+	if stored.RevokedAt != nil {
+		if stored.ReplacedBy == nil {
+			// Revoked by logout or password reset
+			return "", stored, errRefreshInvalid
+		}
+		if time.Since(*stored.RevokedAt) < refreshReuseGrace {
+			return "", stored, racedRefresh(ctx, stored)
+		}
+		logrus.WithContext(ctx).Warnf("Refresh token reuse detected for user %s, revoking session %s", stored.UserID, stored.FamilyID)
+		if err := RevokeSession(ctx, stored.FamilyID); err != nil {
+			logrus.WithContext(ctx).Errorf("Failed to revoke session %s: %v", stored.FamilyID, err)
+		}
+		return "", stored, errRefreshReused
+	}
+
+	var newToken string
+	err = DB(ctx).Transaction(func(tx *gorm.DB) error {
+		var newTokenID uuid.UUID
+		var err error
+		if newToken, newTokenID, err = issueRefreshToken(tx, stored.UserID, stored.FamilyID); err != nil {
+			return err
+		}
+		// Conditional update, only one of the parallel requests can rotate the token
+		result := tx.Model(&model.RefreshToken{}).
+			Where("token_id = ? AND revoked_at IS NULL", stored.TokenID).
+			Updates(map[string]interface{}{"revoked_at": time.Now(), "replaced_by": newTokenID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRefreshRaced
+		}
+		// Sliding expiry, the session stays alive as long as it is used
+		now := time.Now()
+		return tx.Model(&model.Session{}).
+			Where("session_id = ?", stored.FamilyID).
+			Updates(map[string]interface{}{"last_used_at": now, "expires_at": now.Add(authConfig.RefreshTokenExpiry)}).Error
+	})
+	if errors.Is(err, errRefreshRaced) {
+		return "", stored, racedRefresh(ctx, stored)
+	}
+	if err != nil {
+		return "", stored, err
+	}
+	return newToken, stored, nil
+}
+
+// racedRefresh lets a request which lost the rotation through only while its session is still alive,
+// a logout or revocation in the meantime must not be answered with a fresh access token.
+func racedRefresh(ctx context.Context, stored model.RefreshToken) error {
+	var active int64
+	if err := DB(ctx).Model(&model.Session{}).
+		Where("session_id = ? AND revoked_at IS NULL AND expires_at > ?", stored.FamilyID, time.Now()).
+		Count(&active).Error; err != nil {
+		return err
+	}
+	if active == 0 {
+		return errRefreshInvalid
+	}
+	return errRefreshRaced
+}
+
+// RevokeSession logs out a single session, its refresh tokens stop working immediately
+// and the access token already issued to it expires within authConfig.TokenExpiration.
+func RevokeSession(ctx context.Context, sessionID uuid.UUID) error {
+	return DB(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&model.RefreshToken{}).
+			Where("family_id = ? AND revoked_at IS NULL", sessionID).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.Session{}).
+			Where("session_id = ? AND revoked_at IS NULL", sessionID).
+			Update("revoked_at", now).Error
+	})
+}
+
+// RevokeAllSessions logs the user out of every device except the keep session, pass uuid.Nil to keep none.
+func RevokeAllSessions(ctx context.Context, userID uuid.UUID, keep uuid.UUID) error {
+	return DB(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&model.RefreshToken{}).
+			Where("user_id = ? AND family_id <> ? AND revoked_at IS NULL", userID, keep).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.Session{}).
+			Where("user_id = ? AND session_id <> ? AND revoked_at IS NULL", userID, keep).
+			Update("revoked_at", now).Error
+	})
+}
+
+// sessionTwoFactor tells if the session passed the second factor at login
+func sessionTwoFactor(ctx context.Context, sessionID uuid.UUID) bool {
+	var session model.Session
+	if err := DB(ctx).Select("two_factor").First(&session, "session_id = ?", sessionID).Error; err != nil {
+		return false
+	}
+	return session.TwoFactor
+}
+
+// MarkSessionTwoFactor records that the session passed the second factor (like right after enabling 2FA)
+func MarkSessionTwoFactor(ctx context.Context, sessionID uuid.UUID) error {
+	return DB(ctx).Model(&model.Session{}).
+		Where("session_id = ?", sessionID).
+		Update("two_factor", true).Error
+}
+
+// CurrentSessionID finds the session of the request from its refresh cookie
+func CurrentSessionID(c *gin.Context) (uuid.UUID, bool) {
+	refreshToken, err := c.Cookie("refresh_token")
+	if err != nil {
+		return uuid.Nil, false
+	}
+	stored, err := findRefreshToken(c.Request.Context(), refreshToken)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return stored.FamilyID, true
+}
+
+// RevokeRefreshCookie revokes the session of the refresh cookie sent with the request (logout)
+func RevokeRefreshCookie(c *gin.Context) error {
+	sessionID, ok := CurrentSessionID(c)
+	if !ok {
+		// Nothing to revoke
+		return nil
+	}
+	return RevokeSession(c.Request.Context(), sessionID)
+}
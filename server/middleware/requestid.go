@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"compass/connections"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// An id from the proxy (nginx $request_id) is kept so its logs match ours, anything else is replaced
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{8,64}$`)
+
+// RequestID tags every request with an id, sent back in X-Request-ID and carried in the request context,
+// log with logrus.WithContext(c.Request.Context()) to have it in the entry. Queue jobs published with
+// that context carry it as well, so the worker logs of a job match the request that made it.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.NewString()
+		}
+		c.Set("requestID", id)
+		c.Header(requestIDHeader, id)
+		c.Request = c.Request.WithContext(connections.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// AccessLog writes a line per request through logrus, in place of gin.Logger
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		c.Next()
+
+		fields := logrus.Fields{
+			"status":     c.Writer.Status(),
+			"method":     c.Request.Method,
+			"path":       path,
+			"latency_ms": time.Since(start).Milliseconds(),
+			"ip":         c.ClientIP(),
+			"bytes":      c.Writer.Size(),
+		}
+		if userID, ok := c.Get("userID"); ok {
+			fields["user_id"] = userID
+		}
+		if len(c.Errors) > 0 {
+			fields["errors"] = c.Errors.String()
+		}
+		entry := logrus.WithContext(c.Request.Context()).WithFields(fields)
+		switch status := c.Writer.Status(); {
+		case status >= 500:
+			entry.Error("request")
+		case status >= 400:
+			entry.Warn("request")
+		default:
+			entry.Info("request")
+		}
+	}
+}
@@ -1,8 +1,8 @@
 package middleware
 
 import (
-	"compass/connections"
 	"compass/model"
+	"context"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -11,25 +11,43 @@ import (
 	"gorm.io/gorm"
 )
 
-func GenerateRefreshToken(userID uuid.UUID) (string, error) {
-	claims := JWTClaimsRefresh{
-		UserID: userID.String(),
-		RegisteredClaims: jwt.RegisteredClaims{
-			Subject:   userID.String(),
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(authConfig.RefreshTokenExpiry)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "pclub",
-		},
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(authConfig.JWTSecretKey))
+// GenerateRefreshToken starts a new session for the device making the request and issues its first refresh token,
+// twoFactor records whether the login passed the second factor.
+func GenerateRefreshToken(c *gin.Context, userID uuid.UUID, twoFactor bool) (string, error) {
+	var token string
+	err := DB(c).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		session := model.Session{
+			SessionID:  uuid.New(),
+			UserID:     userID,
+			UserAgent:  c.Request.UserAgent(),
+			IP:         c.ClientIP(),
+			LastUsedAt: now,
+			ExpiresAt:  now.Add(authConfig.RefreshTokenExpiry),
+			TwoFactor:  twoFactor,
+		}
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		var err error
+		token, _, err = issueRefreshToken(tx, userID, session.SessionID)
+		return err
+	})
+	return token, err
 }
 
-func GenerateAccessToken(userID uuid.UUID) (string, error) {
+func GenerateAccessToken(ctx context.Context, userID uuid.UUID, twoFactor bool) (string, error) {
+	claims, err := accessClaims(ctx, userID, twoFactor, authConfig.TokenExpiration)
+	if err != nil {
+		return "", err
+	}
+	return keys.sign(AccessToken, claims)
+}
 
+// accessClaims builds the claims of an access token from the current state of the user
+func accessClaims(ctx context.Context, userID uuid.UUID, twoFactor bool, expiry time.Duration) (JWTClaims, error) {
 	var modelUser model.User
-	result := connections.DB.
+	result := DB(ctx).
 		Model(&model.User{}).
 		// Here we need to keep the user_id in the select query for a very specific reason, if we don't have them the query can't join it with the profile table and we will always have the visibility false
 		Select("user_id", "role", "is_verified").
@@ -40,7 +58,7 @@ func GenerateAccessToken(userID uuid.UUID) (string, error) {
 		First(&modelUser)
 
 	if result.Error != nil {
-		return "", result.Error
+		return JWTClaims{}, result.Error
 	}
 
 	role := int(modelUser.Role)
@@ -52,15 +70,48 @@ func GenerateAccessToken(userID uuid.UUID) (string, error) {
 		Role:       role,
 		Verified:   verified,
 		Visibility: visibility,
+		TwoFactor:  twoFactor,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   userID.String(),
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(authConfig.TokenExpiration)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "pclub",
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(authConfig.JWTSecretKey))
+	return claims, nil
+}
+
+// TokenType tells what a token is for, it goes in the typ header. All the tokens are signed by the same keys,
+// the type keeps a token of one kind (like a 2FA challenge) from being accepted as another (like a magic link).
+type TokenType string
+
+const (
+	AccessToken             TokenType = "access+jwt"
+	RefreshToken            TokenType = "refresh+jwt"
+	TwoFactorChallengeToken TokenType = "2fa-challenge+jwt"
+	MagicLinkToken          TokenType = "magic-link+jwt"
+	OIDCFlowToken           TokenType = "oidc-flow+jwt"
+	PasskeyCeremonyToken    TokenType = "passkey-ceremony+jwt"
+	// legacyRefreshToken is the typ of the refresh tokens issued before the types, they are still matched
+	// against the stored hash so no other token can pass as one
+	legacyRefreshToken TokenType = "JWT"
+)
+
+// SignClaims signs short lived state (like an sso login flow) that the client has to hand back unchanged
+func SignClaims(typ TokenType, claims jwt.Claims) (string, error) {
+	return keys.sign(typ, claims)
+}
+
+// ParseClaims verifies a token from SignClaims, only of the type given, and fills the claims
+func ParseClaims(typ TokenType, tokenString string, claims jwt.Claims) error {
+	token, err := keys.parse(tokenString, claims, typ)
+	if err != nil {
+		return err
+	}
+	if !token.Valid {
+		return jwt.ErrTokenInvalidClaims
+	}
+	return nil
 }
 
 func SetAuthCookie(c *gin.Context, token string) {
@@ -89,6 +140,24 @@ func SetRefreshCookie(c *gin.Context, token string) {
 	)
 }
 
+// SetStateCookie keeps short lived http only state of multi step flows (sso, magic link) in the browser
+func SetStateCookie(c *gin.Context, name string, value string, maxAge time.Duration) {
+	c.SetSameSite(authConfig.SameSiteMode)
+	c.SetCookie(
+		name,
+		value,
+		int(maxAge.Seconds()),
+		"/",
+		authConfig.CookieDomain,
+		authConfig.CookieSecure,
+		true,
+	)
+}
+
+func ClearStateCookie(c *gin.Context, name string) {
+	SetStateCookie(c, name, "", -time.Second)
+}
+
 func ClearAuthCookie(c *gin.Context) {
 	c.SetSameSite(authConfig.SameSiteMode)
 	c.SetCookie(
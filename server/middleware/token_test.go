@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func testClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Subject:   uuid.NewString(),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}
+}
+
+func TestParseClaimsOnlyTakesItsType(t *testing.T) {
+	token, err := SignClaims(TwoFactorChallengeToken, testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims jwt.RegisteredClaims
+	if err := ParseClaims(TwoFactorChallengeToken, token, &claims); err != nil {
+		t.Errorf("token of the type asked rejected: %v", err)
+	}
+	for _, typ := range []TokenType{MagicLinkToken, OIDCFlowToken, PasskeyCeremonyToken, AccessToken} {
+		if err := ParseClaims(typ, token, &claims); err == nil {
+			t.Errorf("2fa challenge accepted as %s", typ)
+		}
+	}
+}
+
+func TestAccessAndRefreshTokensKeptApart(t *testing.T) {
+	access, err := keys.sign(AccessToken, JWTClaims{UserID: uuid.New(), RegisteredClaims: testClaims()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh, err := keys.sign(RefreshToken, JWTClaimsRefresh{RegisteredClaims: testClaims()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.parse(refresh, &JWTClaims{}, AccessToken); err == nil {
+		t.Error("refresh token accepted as an access token")
+	}
+	if _, err := keys.parse(access, &JWTClaimsRefresh{}, RefreshToken, legacyRefreshToken); err == nil {
+		t.Error("access token accepted as a refresh token")
+	}
+	// A state token handed to the client must not pass as a login
+	state, err := SignClaims(OIDCFlowToken, testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.parse(state, &JWTClaims{}, AccessToken); err == nil {
+		t.Error("oidc flow token accepted as an access token")
+	}
+}
+
+// Refresh tokens issued before the types carry the default typ of the jwt library
+func TestLegacyRefreshTokenAccepted(t *testing.T) {
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, JWTClaimsRefresh{RegisteredClaims: testClaims()}).SignedString(keys.hmacSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.parse(legacy, &JWTClaimsRefresh{}, RefreshToken, legacyRefreshToken); err != nil {
+		t.Errorf("legacy refresh token rejected: %v", err)
+	}
+	if _, err := keys.parse(legacy, &JWTClaims{}, AccessToken); err == nil {
+		t.Error("legacy token accepted as an access token")
+	}
+}
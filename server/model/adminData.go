@@ -1,11 +1,32 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type LogActor string
+
+const (
+	AdminActor LogActor = "admin"
+	BotActor   LogActor = "bot" // system generated entries
+	UserActor  LogActor = "user"
+)
 
 type Logs struct {
 	gorm.Model
 	LogId           string `gorm:"uniqueIndex" json:"log_id"`
 	Title           string `json:"title" binding:"required"`
 	Description     string `json:"description"`
-	ActionTaker Role   `gorm:"type:varchar(10);check:action_taker IN ('admin','bot','user')"`
+	ActionTaker LogActor `gorm:"type:varchar(10);check:action_taker IN ('admin','bot','user')"`
+}
+
+// NewLog builds an admin log entry with a fresh LogId, as LogId is unique it can't be left empty
+func NewLog(title string, description string, actor LogActor) Logs {
+	return Logs{
+		LogId:       uuid.NewString(),
+		Title:       title,
+		Description: description,
+		ActionTaker: actor,
+	}
 }
@@ -0,0 +1,134 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+type OTPPurpose string
+
+const (
+	PasswordResetOTP OTPPurpose = "password_reset"
+	EmailChangeOTP   OTPPurpose = "email_change"
+	MagicLinkOTP     OTPPurpose = "magic_link"
+)
+
+// OneTimeCode stores the hashed OTPs mailed to the user for sensitive actions,
+// a user can have only one live code per purpose, requesting a new one replaces the old.
+type OneTimeCode struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserID    uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	Purpose   OTPPurpose `gorm:"type:varchar(30);primaryKey"`
+	CodeHash  string     `json:"-"`
+	Target    string     // what the code confirms, like the new address of an email change
+	ExpiresAt time.Time
+	Attempts  int
+	User      *User `gorm:"foreignKey:UserID;references:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+}
+
+// Session is a single login on a device, it lives as long as its refresh token keeps getting rotated
+type Session struct {
+	SessionID  uuid.UUID  `gorm:"type:uuid;primaryKey" json:"sessionId"`
+	UserID     uuid.UUID  `gorm:"type:uuid;index" json:"-"`
+	UserAgent  string     `json:"userAgent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt time.Time  `json:"lastUsedAt"` // last refresh, so accurate up to the access token expiry
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	TwoFactor  bool       `json:"twoFactor"` // login passed the second factor
+	RevokedAt  *time.Time `json:"-"`
+	User       *User      `gorm:"foreignKey:UserID;references:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+}
+
+// RefreshToken is the server side record of an issued refresh token, only the hash is stored.
+// Every refresh rotates the token, the tokens rotated out of a single login form a family (the session).
+type RefreshToken struct {
+	CreatedAt  time.Time
+	TokenID    uuid.UUID  `gorm:"type:uuid;primaryKey"` // jti claim of the jwt
+	FamilyID   uuid.UUID  `gorm:"type:uuid;index"`      // SessionID of the login
+	UserID     uuid.UUID  `gorm:"type:uuid;index"`
+	TokenHash  string     `json:"-"`
+	ExpiresAt  time.Time  `gorm:"index"`
+	RevokedAt  *time.Time // set on rotation, logout or password reset
+	ReplacedBy *uuid.UUID `gorm:"type:uuid"` // token issued in place of this one on rotation
+	User       *User      `gorm:"foreignKey:UserID;references:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+}
+
+// Throttle counts the recent attempts of a key (like "login:email:<email>") to slow down brute force.
+// Count resets once the window passes, BlockedUntil is when the key may try again.
+type Throttle struct {
+	Key          string `gorm:"primaryKey"`
+	Count        int
+	WindowStart  time.Time
+	LastAt       time.Time
+	BlockedUntil *time.Time
+	UpdatedAt    time.Time `gorm:"index"`
+}
+
+// TwoFactor is the TOTP enrollment of a user, it is enabled once the first code is verified
+type TwoFactor struct {
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	UserID       uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Secret       string    `json:"-"` // base32 totp secret
+	Enabled      bool
+	EnabledAt    *time.Time
+	LastUsedStep int64 `json:"-"` // time step of the last accepted code, a code can't be replayed
+	User         *User `gorm:"foreignKey:UserID;references:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+}
+
+// RecoveryCode is a single use code to login when the authenticator app is lost
+type RecoveryCode struct {
+	ID       uint      `gorm:"primaryKey"`
+	UserID   uuid.UUID `gorm:"type:uuid;index"`
+	CodeHash string    `json:"-"`
+	UsedAt   *time.Time
+	User     *User `gorm:"foreignKey:UserID;references:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+}
+
+// APIKey lets a bot or service account call the api with an "Authorization: Bearer <key>" header.
+// Only the sha256 of the key is stored, the key itself is shown once when minted.
+type APIKey struct {
+	KeyID     uuid.UUID    `gorm:"type:uuid;primaryKey" json:"keyId"`
+	Name      string       `json:"name"`
+	Prefix    string       `json:"prefix"` // start of the key, to recognise it in the list
+	KeyHash   string       `gorm:"uniqueIndex" json:"-"`
+	UserID    uuid.UUID    `gorm:"type:uuid;index" json:"userId"` // account the key acts as
+	Scopes    []Permission `gorm:"serializer:json" json:"scopes"` // permissions the key may use, never more than the role of the account has
+	CreatedBy uuid.UUID    `gorm:"type:uuid" json:"createdBy"`
+	// The request minting the key passed the second factor, which may be of another account (CreatedBy).
+	// Admin accounts need it, a key of another admin account can't be minted without it.
+	MintedWithTwoFactor bool       `json:"mintedWithTwoFactor"`
+	CreatedAt           time.Time  `json:"createdAt"`
+	ExpiresAt           *time.Time `json:"expiresAt"` // nil never expires
+	LastUsedAt          *time.Time `json:"lastUsedAt"`
+	RevokedAt           *time.Time `json:"revokedAt"`
+	User                *User      `gorm:"foreignKey:UserID;references:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+}
+
+// Passkey is a WebAuthn credential of the user, one per device or security key
+type Passkey struct {
+	ID           uint                `gorm:"primaryKey" json:"id"`
+	UserID       uuid.UUID           `gorm:"type:uuid;index" json:"-"`
+	Name         string              `json:"name"`
+	CredentialID []byte              `gorm:"uniqueIndex" json:"-"`
+	Credential   webauthn.Credential `gorm:"serializer:json" json:"-"` // public key, sign count and flags
+	CreatedAt    time.Time           `json:"createdAt"`
+	LastUsedAt   *time.Time          `json:"lastUsedAt"`
+	User         *User               `gorm:"foreignKey:UserID;references:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+}
+
+// Impersonation is an admin viewing the app as a user, it ends with a stop or at ExpiresAt.
+// The admin logs hold its start and its end, the cleanup worker writes the end of those left to expire.
+type Impersonation struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"` // jti of the impersonation token
+	AdminID   uuid.UUID `gorm:"type:uuid;index"`
+	UserID    uuid.UUID `gorm:"type:uuid"`
+	Elevated  bool
+	CreatedAt time.Time
+	ExpiresAt time.Time  `gorm:"index"`
+	EndedAt   *time.Time // stopped by the admin or, once past ExpiresAt, logged as expired
+}
@@ -2,6 +2,7 @@ package model
 
 const MailQueue string = "mail"
 const ModerationQueue string = "moderation"
+const ExportQueue string = "export"
 
 const ModerationRoute = "https://api.openai.com/v1/moderations"
 const ModerationTypeReviewText = "reviewText"
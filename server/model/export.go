@@ -0,0 +1,27 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ExportStatus string
+
+const (
+	ExportPending ExportStatus = "pending"
+	ExportReady   ExportStatus = "ready"
+	ExportFailed  ExportStatus = "failed"
+)
+
+// DataExport is a "download my data" request, the archive is built by the export worker
+type DataExport struct {
+	ExportID    uuid.UUID    `gorm:"type:uuid;primaryKey" json:"exportId"`
+	UserID      uuid.UUID    `gorm:"type:uuid;index" json:"-"`
+	Status      ExportStatus `gorm:"type:varchar(10)" json:"status"`
+	FilePath    string       `json:"-"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	CompletedAt *time.Time   `json:"completedAt"`
+	ExpiresAt   *time.Time   `gorm:"index" json:"expiresAt"` // the archive is deleted after this
+	User        *User        `gorm:"foreignKey:UserID;references:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+}
@@ -0,0 +1,45 @@
+package model
+
+// Permission is a named action on the dashboard, routes check for it instead of comparing roles
+type Permission string
+
+const (
+	NoticePublishPermission   Permission = "notice.publish"
+	ReviewModeratePermission  Permission = "review.moderate"
+	LocationApprovePermission Permission = "location.approve"
+	UserManagePermission      Permission = "user.manage"
+	DashboardViewPermission   Permission = "dashboard.view" // logs and indicators
+	UserImpersonatePermission Permission = "user.impersonate"
+)
+
+// PermissionDef is the list of known permissions
+type PermissionDef struct {
+	Name        Permission `gorm:"type:varchar(50);primaryKey" json:"name"`
+	Description string     `json:"description"`
+}
+
+func (PermissionDef) TableName() string {
+	return "permissions"
+}
+
+// RolePermission grants a permission to every user of the role, editable in the db without a deploy
+type RolePermission struct {
+	Role       Role          `gorm:"type:int;primaryKey" json:"role"`
+	Permission Permission    `gorm:"type:varchar(50);primaryKey" json:"permission"`
+	Def        PermissionDef `gorm:"foreignKey:Permission;references:Name;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+}
+
+// DefaultPermissions are seeded at startup, the roles listed get the permission
+// only when it is seen for the first time, so later edits in the db are kept.
+// Bot accounts get the dashboard work a script can do, their api keys are scoped to a part of it when minted.
+var DefaultPermissions = []struct {
+	Def   PermissionDef
+	Roles []Role
+}{
+	{PermissionDef{DashboardViewPermission, "View the system logs and indicators"}, []Role{ModeratorRole, Bot, AdminRole, SuperAdminRole}},
+	{PermissionDef{ReviewModeratePermission, "Approve or reject flagged reviews"}, []Role{ModeratorRole, Bot, AdminRole, SuperAdminRole}},
+	{PermissionDef{LocationApprovePermission, "Approve or reject new locations"}, []Role{ModeratorRole, Bot, AdminRole, SuperAdminRole}},
+	{PermissionDef{NoticePublishPermission, "Publish notices"}, []Role{Bot, AdminRole, SuperAdminRole}},
+	{PermissionDef{UserManagePermission, "Change the role of users"}, []Role{SuperAdminRole}},
+	{PermissionDef{UserImpersonatePermission, "View the app as another user to debug their reports"}, []Role{SuperAdminRole}},
+}
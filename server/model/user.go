@@ -9,13 +9,26 @@ import (
 
 type Role int
 
+// Access is granted through the permissions of the role (see RolePermission),
+// the order only matters for who may assign which role.
 const (
-	AdminRole Role = 100 // "admin"
-	Bot       Role = 99  // "bot"
-	UserRole  Role = 50  // "user"
-	// TODO: add roles like Super Admin, Visitors
+	SuperAdminRole Role = 150 // "super admin", manages the users and their roles
+	AdminRole      Role = 100 // "admin"
+	Bot            Role = 99  // "bot"
+	ModeratorRole  Role = 75  // "moderator", reviews the user contributions
+	UserRole       Role = 50  // "user"
+	// TODO: add roles like Visitors
 )
 
+// ValidRole tells if the value is one of the defined roles
+func ValidRole(role Role) bool {
+	switch role {
+	case SuperAdminRole, AdminRole, Bot, ModeratorRole, UserRole:
+		return true
+	}
+	return false
+}
+
 type User struct {
 	CreatedAt time.Time      `json:"-"`
 	UpdatedAt time.Time      `json:"-"`
@@ -28,6 +41,10 @@ type User struct {
 	IsVerified        bool      `json:"-"`
 	VerificationToken string    `json:"-"` //erased after verification
 	Role              Role      `json:"role" gorm:"type:int;"`
+	// Opted in to login with a link mailed to the email, see auth/handler.magic.go
+	PasswordlessEnabled bool `json:"passwordlessEnabled"`
+	// Set when the user asks to delete the account, purged after deletion.gracePeriod unless they login again
+	DeletionRequestedAt *time.Time `gorm:"index" json:"deletionRequestedAt,omitempty"`
 
 	// Search Profile
 	Profile Profile `gorm:"foreignKey:UserID;references:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"profile"`
@@ -0,0 +1,102 @@
+package search
+
+import (
+	"compass/connections"
+	"compass/middleware"
+	"compass/model"
+	"compass/testutil"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestMain loads the config of the server without connecting to its services, the tests set up the DB they use
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	connections.LoadConfig("../")
+	middleware.Init()
+	os.Exit(m.Run())
+}
+
+// newSearchTest sets up the db and the search router with the middlewares of the search server, and a
+// browser session of a visible profile: the auth and csrf cookies, like the data worker sends them.
+func newSearchTest(t *testing.T) (*gin.Engine, []*http.Cookie, model.Profile) {
+	t.Helper()
+	testutil.UseDB(t)
+	r := gin.New()
+	r.Use(middleware.Errors(), middleware.CSRF())
+	Router(r)
+
+	user := model.User{Email: "student@iitk.ac.in", IsVerified: true, Role: model.UserRole}
+	if err := connections.DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	profile := model.Profile{UserID: user.UserID, Name: "Student", Email: user.Email, Visibility: true}
+	if err := connections.DB.Create(&profile).Error; err != nil {
+		t.Fatal(err)
+	}
+	token, err := middleware.GenerateAccessToken(t.Context(), user.UserID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := []*http.Cookie{
+		{Name: "auth_token", Value: token},
+		{Name: "csrf_token", Value: "unreadable-from-the-worker"},
+	}
+	return r, cookies, profile
+}
+
+func serve(r *gin.Engine, req *http.Request, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestChangeLogWithoutCSRFHeader(t *testing.T) {
+	r, cookies, profile := newSearchTest(t)
+	since := time.Now().Add(-time.Minute)
+	if err := connections.DB.Create(&model.ChangeLog{UserID: profile.UserID, Action: model.Update}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	query := url.Values{"lastUpdateTime": {since.UTC().Format("2006-01-02T15:04:05.000Z")}}
+	w := serve(r, httptest.NewRequest(http.MethodGet, "/api/search/changeLog?"+query.Encode(), nil), cookies)
+	if w.Code != http.StatusOK {
+		t.Fatalf("changeLog status = %d, body %s", w.Code, w.Body)
+	}
+	var body struct {
+		AddProfiles []model.Profile `json:"addProfiles"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.AddProfiles) != 1 || body.AddProfiles[0].UserID != profile.UserID {
+		t.Errorf("changeLog gave %+v, want the updated profile", body.AddProfiles)
+	}
+}
+
+func TestChangeLogNeedsLastUpdateTime(t *testing.T) {
+	r, cookies, _ := newSearchTest(t)
+	w := serve(r, httptest.NewRequest(http.MethodGet, "/api/search/changeLog", nil), cookies)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("changeLog without lastUpdateTime status = %d, body %s", w.Code, w.Body)
+	}
+}
+
+// The writes of the same session still need the header
+func TestWriteWithoutCSRFHeaderRejected(t *testing.T) {
+	r, cookies, _ := newSearchTest(t)
+	req := httptest.NewRequest(http.MethodPost, "/api/search/toggleVisibility", nil)
+	if w := serve(r, req, cookies); w.Code != http.StatusForbidden {
+		t.Fatalf("toggleVisibility without the csrf header status = %d, body %s", w.Code, w.Body)
+	}
+}
@@ -1,48 +1,41 @@
 package search
 
 import (
-	"compass/connections"
 	"compass/middleware"
 	"compass/model"
+	"compass/workers"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
+// deleteProfileData schedules the deletion of the account, the profile is hidden at once
+// and the account purged after the grace period unless the user logs in again.
 func deleteProfileData(c *gin.Context) {
 	userID, _ := c.Get("userID")
-	var existingProfile model.Profile
-	if err := connections.DB.Where(model.Profile{UserID: userID.(uuid.UUID)}).First(&existingProfile).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User Profile not found"})
-		return
-	}
-	if err := connections.DB.Transaction(func(tx *gorm.DB) error {
-		// soft delete from "profiles" table
-		// TODO: We may need hard delete, due to unique key constraint
-		if err := tx.Delete(&model.Profile{}, "user_id = ?", existingProfile.UserID).Error; err != nil {
-			return err
-		}
-		// Delete any pre existing log for user
-		if err := tx.Where("user_id = ?", existingProfile.UserID).Delete(&model.ChangeLog{}).Error; err != nil {
-			return err
-		}
-		// create log entry
-		if err := tx.Create(&model.ChangeLog{UserID: existingProfile.UserID, Action: model.Delete}).Error; err != nil {
-			return err
-		}
-		// Soft delete from "users" table
-		if err := tx.Delete(&model.User{}, "user_id = ?", existingProfile.UserID).Error; err != nil {
-			return err
+	purgeAt, err := workers.ScheduleAccountDeletion(c.Request.Context(), userID.(uuid.UUID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			middleware.Fail(c, middleware.NotFound("User Profile not found"))
+			return
 		}
-		return nil
-	}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to delete profile"})
+		middleware.Fail(c, middleware.Internal(err, "Unable to delete profile"))
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "User profile data deleted successfully"})
+	// Logged out everywhere, logging in again cancels the deletion
+	if err := middleware.RevokeAllSessions(c.Request.Context(), userID.(uuid.UUID), uuid.Nil); err != nil {
+		logrus.WithContext(c.Request.Context()).Errorf("Failed to revoke sessions of user %s: %v", userID, err)
+	}
+	middleware.ClearAuthCookie(c)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Your account will be deleted, login again before the date to cancel",
+		"purgeAt": purgeAt,
+	})
 }
 
 func toggleVisibility(c *gin.Context) {
@@ -50,10 +43,10 @@ func toggleVisibility(c *gin.Context) {
 	var input toggleVisibilityRequest
 	// Request Validation
 	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		middleware.Fail(c, middleware.InvalidInput(err))
 		return
 	}
-	if err := connections.DB.Transaction(func(tx *gorm.DB) error {
+	if err := middleware.DB(c).Transaction(func(tx *gorm.DB) error {
 		updateAction := model.Delete
 		if *input.Visibility {
 			updateAction = model.Update
@@ -91,23 +84,21 @@ func toggleVisibility(c *gin.Context) {
 		return nil
 	}); err != nil {
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User profile not found."})
+			middleware.Fail(c, middleware.NotFound("User profile not found."))
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to update visibility at the moment."})
+		middleware.Fail(c, middleware.Internal(err, "Unable to update visibility at the moment."))
 		return
 	}
 
 	// TODO: We can extract out this token refresh logic
-	// Clear the old cookie with visibility true
-	middleware.ClearAuthCookie(c)
-	token, err := middleware.GenerateAccessToken(userID.(uuid.UUID))
-	ref_token, _ := middleware.GenerateRefreshToken(userID.(uuid.UUID))
+	// Only the access token carries the visibility, the refresh token (session) stays the same
+	token, err := middleware.GenerateAccessToken(c.Request.Context(), userID.(uuid.UUID), c.GetBool("twoFactor"))
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{"message": "visibility updated successfully, please login again to continue"})
+		return
 	}
 	middleware.SetAuthCookie(c, token)
-	middleware.SetRefreshCookie(c, ref_token)
 
 	c.JSON(http.StatusOK, gin.H{"message": "visibility updated successfully"})
 
@@ -3,7 +3,7 @@ package search
 import "time"
 
 type changeLogRequest struct {
-	LastUpdateTime time.Time `json:"lastUpdateTime" binding:"required"`
+	LastUpdateTime time.Time `form:"lastUpdateTime" binding:"required"` // RFC 3339, like toISOString() gives
 }
 
 type toggleVisibilityRequest struct {
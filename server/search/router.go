@@ -11,12 +11,13 @@ func Router(r *gin.Engine) {
     search.Use(middleware.UserAuthenticator)
 
     search.POST("/toggleVisibility", toggleVisibility)
-    search.DELETE("/", deleteProfileData)
+    search.DELETE("/", middleware.NoImpersonation, deleteProfileData)
 
     protected := search.Group("/") 
-    protected.Use(middleware.CheckVisibility)
+    protected.Use(middleware.CheckVisibility, middleware.RateLimit("profiles"))
     {
         protected.GET("/", getAllProfiles)
-        protected.POST("/changeLog", getChangeLog)
+        // A GET, the sync runs in a web worker which has no document.cookie to read the csrf token from
+        protected.GET("/changeLog", getChangeLog)
     }
 }
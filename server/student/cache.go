@@ -0,0 +1,66 @@
+package student
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	query     Query
+	record    Record
+	err       error
+	expiresAt time.Time
+}
+
+// CachedVerifier remembers the answers by roll number, a repeated submission of the same details
+// doesn't reach OA again. Mismatches are kept for a shorter time, errors of OA itself are not kept.
+type CachedVerifier struct {
+	next        Verifier
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func NewCachedVerifier(next Verifier, ttl time.Duration, negativeTTL time.Duration) *CachedVerifier {
+	return &CachedVerifier{next: next, ttl: ttl, negativeTTL: negativeTTL, entries: make(map[string]cacheEntry)}
+}
+
+func (c *CachedVerifier) Verify(ctx context.Context, query Query) (Record, error) {
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.entries[query.RollNo]
+	c.mu.Unlock()
+	// The answer is only valid for the exact same details
+	if ok && entry.query == query && now.Before(entry.expiresAt) {
+		return entry.record, entry.err
+	}
+
+	record, err := c.next.Verify(ctx, query)
+	var ttl time.Duration
+	switch {
+	case err == nil:
+		ttl = c.ttl
+	case errors.Is(err, ErrNotVerified):
+		ttl = c.negativeTTL
+	}
+	if ttl > 0 {
+		c.mu.Lock()
+		c.evictExpired(now)
+		c.entries[query.RollNo] = cacheEntry{query: query, record: record, err: err, expiresAt: now.Add(ttl)}
+		c.mu.Unlock()
+	}
+	return record, err
+}
+
+// evictExpired keeps the map from growing forever, the number of students is bounded anyway
+func (c *CachedVerifier) evictExpired(now time.Time) {
+	for rollNo, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, rollNo)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package student
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// countingVerifier answers with a fixed result and counts the calls reaching it
+type countingVerifier struct {
+	record Record
+	err    error
+	calls  int
+}
+
+func (v *countingVerifier) Verify(context.Context, Query) (Record, error) {
+	v.calls++
+	return v.record, v.err
+}
+
+var testQuery = Query{RollNo: "230001", Course: "BT", Dept: "CSE", Email: "dev1@iitk.ac.in"}
+
+func TestCachedVerifierRemembersVerified(t *testing.T) {
+	next := &countingVerifier{record: Record{Name: "Dev Student One"}}
+	cached := NewCachedVerifier(next, time.Hour, time.Minute)
+
+	for range 3 {
+		record, err := cached.Verify(context.Background(), testQuery)
+		if err != nil || record.Name != "Dev Student One" {
+			t.Fatalf("Verify() = %v, %v", record, err)
+		}
+	}
+	if next.calls != 1 {
+		t.Errorf("next verifier called %d times, want 1", next.calls)
+	}
+}
+
+func TestCachedVerifierNeedsSameDetails(t *testing.T) {
+	next := &countingVerifier{record: Record{Name: "Dev Student One"}}
+	cached := NewCachedVerifier(next, time.Hour, time.Minute)
+
+	cached.Verify(context.Background(), testQuery)
+	other := testQuery
+	other.Dept = "EE"
+	cached.Verify(context.Background(), other)
+	if next.calls != 2 {
+		t.Errorf("next verifier called %d times, want 2", next.calls)
+	}
+}
+
+func TestCachedVerifierRemembersMismatch(t *testing.T) {
+	next := &countingVerifier{err: ErrNotVerified}
+	cached := NewCachedVerifier(next, time.Hour, time.Minute)
+
+	for range 2 {
+		if _, err := cached.Verify(context.Background(), testQuery); !errors.Is(err, ErrNotVerified) {
+			t.Fatalf("Verify() error = %v, want ErrNotVerified", err)
+		}
+	}
+	if next.calls != 1 {
+		t.Errorf("next verifier called %d times, want 1", next.calls)
+	}
+}
+
+func TestCachedVerifierSkipsFailures(t *testing.T) {
+	for _, failure := range []error{ErrUnavailable, ErrUnauthorized} {
+		next := &countingVerifier{err: failure}
+		cached := NewCachedVerifier(next, time.Hour, time.Minute)
+
+		cached.Verify(context.Background(), testQuery)
+		cached.Verify(context.Background(), testQuery)
+		if next.calls != 2 {
+			t.Errorf("%v: next verifier called %d times, want 2", failure, next.calls)
+		}
+	}
+}
+
+func TestCachedVerifierExpires(t *testing.T) {
+	next := &countingVerifier{record: Record{Name: "Dev Student One"}}
+	cached := NewCachedVerifier(next, time.Millisecond, time.Millisecond)
+
+	cached.Verify(context.Background(), testQuery)
+	time.Sleep(5 * time.Millisecond)
+	cached.Verify(context.Background(), testQuery)
+	if next.calls != 2 {
+		t.Errorf("next verifier called %d times, want 2", next.calls)
+	}
+}
@@ -0,0 +1,52 @@
+package student
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// FixtureStudent is an entry of the fixture file
+type FixtureStudent struct {
+	Query
+	Name string `json:"name"`
+}
+
+// FixtureVerifier answers from a json file of students, for development without access to OA
+type FixtureVerifier struct {
+	students map[string]FixtureStudent // by roll number
+}
+
+func LoadFixture(path string) (*FixtureVerifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var students []FixtureStudent
+	if err := json.Unmarshal(data, &students); err != nil {
+		return nil, err
+	}
+	fixture := &FixtureVerifier{students: make(map[string]FixtureStudent, len(students))}
+	for _, s := range students {
+		fixture.students[s.RollNo] = s
+	}
+	return fixture, nil
+}
+
+// Lookup finds the student matching all the fields of the query, like OA does
+func (f *FixtureVerifier) Lookup(query Query) (FixtureStudent, bool) {
+	s, ok := f.students[query.RollNo]
+	if !ok || s.Course != query.Course || s.Dept != query.Dept || !strings.EqualFold(s.Email, query.Email) {
+		return FixtureStudent{}, false
+	}
+	return s, true
+}
+
+func (f *FixtureVerifier) Verify(_ context.Context, query Query) (Record, error) {
+	s, ok := f.Lookup(query)
+	if !ok {
+		return Record{}, ErrNotVerified
+	}
+	return Record{Name: s.Name}, nil
+}
@@ -0,0 +1,98 @@
+package student
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// OAResponse is the response of the OA verification api, the name is returned only on success
+type OAResponse struct {
+	RollNumber *string `json:"rollNumber"`
+	Name       *string `json:"name"`
+	Email      *string `json:"email"`
+	Status     *string `json:"status"`
+	Timestamp  *string `json:"timestamp"`
+	Message    *string `json:"message"`
+}
+
+// OAVerifier calls the OA verification api over http
+type OAVerifier struct {
+	url     string
+	key     string
+	retries int
+	client  *http.Client
+}
+
+func NewOAVerifier(url string, key string, retries int) *OAVerifier {
+	return &OAVerifier{
+		url:     url,
+		key:     key,
+		retries: max(retries, 1),
+		client:  &http.Client{Timeout: 10 * time.Second, Transport: otelhttp.NewTransport(http.DefaultTransport)},
+	}
+}
+
+// ParamKey is the single query parameter the OA api takes
+func ParamKey(query Query) string {
+	return strings.Join([]string{query.RollNo, query.Course, query.Dept, query.Email}, ":")
+}
+
+// Verify retries with a backoff on network errors and 5xx, the other responses are final
+func (o *OAVerifier) Verify(ctx context.Context, query Query) (Record, error) {
+	var lastErr error
+	for attempt := range o.retries {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return Record{}, fmt.Errorf("%w: %v", ErrUnavailable, ctx.Err())
+			case <-time.After(time.Duration(attempt*attempt) * 250 * time.Millisecond):
+			}
+		}
+		record, err, retry := o.verifyOnce(ctx, query)
+		if !retry {
+			return record, err
+		}
+		lastErr = err
+	}
+	return Record{}, lastErr
+}
+
+func (o *OAVerifier) verifyOnce(ctx context.Context, query Query) (record Record, err error, retry bool) {
+	reqURL := o.url + "?" + url.Values{"paramkey": {ParamKey(query)}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return Record{}, fmt.Errorf("%w: %v", ErrUnavailable, err), false
+	}
+	req.Header.Set("x-api-key", o.key)
+	req.Header.Set("Accept", "application/json")
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return Record{}, fmt.Errorf("%w: %v", ErrUnavailable, err), true
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return Record{}, ErrUnauthorized, false
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return Record{}, fmt.Errorf("%w: status %d", ErrUnavailable, resp.StatusCode), true
+	case resp.StatusCode != http.StatusOK:
+		return Record{}, fmt.Errorf("%w: status %d", ErrUnavailable, resp.StatusCode), false
+	}
+
+	var apiResp OAResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return Record{}, fmt.Errorf("%w: invalid response: %v", ErrUnavailable, err), false
+	}
+	if apiResp.Status == nil || *apiResp.Status != "true" || apiResp.Name == nil {
+		return Record{}, ErrNotVerified, false
+	}
+	return Record{Name: *apiResp.Name}, nil, false
+}
@@ -0,0 +1,88 @@
+package student
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// oaServer answers every request with the given statuses in turn, the last one repeats
+func oaServer(t *testing.T, statuses ...int) (*httptest.Server, *int) {
+	t.Helper()
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[min(calls, len(statuses)-1)]
+		calls++
+		if r.Header.Get("x-api-key") != "test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		verified := r.URL.Query().Get("paramkey") == ParamKey(testQuery)
+		statusField, name := "false", ""
+		if verified {
+			statusField, name = "true", "Dev Student One"
+		}
+		resp := OAResponse{Status: &statusField}
+		if verified {
+			resp.Name = &name
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestOAVerifierVerified(t *testing.T) {
+	server, _ := oaServer(t, http.StatusOK)
+	record, err := NewOAVerifier(server.URL, "test-key", 1).Verify(context.Background(), testQuery)
+	if err != nil || record.Name != "Dev Student One" {
+		t.Fatalf("Verify() = %v, %v", record, err)
+	}
+}
+
+func TestOAVerifierNotVerified(t *testing.T) {
+	server, _ := oaServer(t, http.StatusOK)
+	other := testQuery
+	other.Course = "MT"
+	if _, err := NewOAVerifier(server.URL, "test-key", 1).Verify(context.Background(), other); !errors.Is(err, ErrNotVerified) {
+		t.Fatalf("Verify() error = %v, want ErrNotVerified", err)
+	}
+}
+
+func TestOAVerifierRetriesServerErrors(t *testing.T) {
+	server, calls := oaServer(t, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK)
+	record, err := NewOAVerifier(server.URL, "test-key", 3).Verify(context.Background(), testQuery)
+	if err != nil || record.Name != "Dev Student One" {
+		t.Fatalf("Verify() = %v, %v", record, err)
+	}
+	if *calls != 3 {
+		t.Errorf("OA called %d times, want 3", *calls)
+	}
+}
+
+func TestOAVerifierGivesUp(t *testing.T) {
+	server, calls := oaServer(t, http.StatusServiceUnavailable)
+	if _, err := NewOAVerifier(server.URL, "test-key", 2).Verify(context.Background(), testQuery); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Verify() error = %v, want ErrUnavailable", err)
+	}
+	if *calls != 2 {
+		t.Errorf("OA called %d times, want 2", *calls)
+	}
+}
+
+func TestOAVerifierRejectedKey(t *testing.T) {
+	server, calls := oaServer(t, http.StatusOK)
+	if _, err := NewOAVerifier(server.URL, "wrong-key", 3).Verify(context.Background(), testQuery); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Verify() error = %v, want ErrUnauthorized", err)
+	}
+	if *calls != 1 {
+		t.Errorf("OA called %d times, want 1, a rejected key is not retried", *calls)
+	}
+}
@@ -0,0 +1,68 @@
+// Verification of the profile details (roll number, course, department) of a student against the institute records
+package student
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	// ErrNotVerified means the details do not match the institute records
+	ErrNotVerified = errors.New("student details not verified")
+	// ErrUnauthorized means our credentials for the records api were rejected, needs a new key
+	ErrUnauthorized = errors.New("student records api rejected the credentials")
+	// ErrUnavailable means the records could not be reached, the user may try again later
+	ErrUnavailable = errors.New("student records api unavailable")
+)
+
+// Query is what the institute records are checked against, the email ties the roll number to the account
+type Query struct {
+	RollNo string `json:"rollNo"`
+	Course string `json:"course"`
+	Dept   string `json:"dept"`
+	Email  string `json:"email"`
+}
+
+// Record is what the institute records return for a verified query
+type Record struct {
+	Name string `json:"name"`
+}
+
+// Verifier checks a query against the institute records, the errors are one of the above (possibly wrapped)
+type Verifier interface {
+	Verify(ctx context.Context, query Query) (Record, error)
+}
+
+// NewVerifier builds the verifier selected with oa.provider, wrapped in the cache
+func NewVerifier() (Verifier, error) {
+	var verifier Verifier
+	switch provider := viper.GetString("oa.provider"); provider {
+	case "http", "":
+		verifier = NewOAVerifier(
+			viper.GetString("oa.url"),
+			viper.GetString("oa.key"),
+			viper.GetInt("oa.retries"),
+		)
+	case "fixture":
+		// Anyone could verify as one of the made up students
+		if viper.GetString("env") == "prod" {
+			return nil, errors.New("oa.provider fixture is for development only")
+		}
+		fixture, err := LoadFixture(viper.GetString("oa.fixture"))
+		if err != nil {
+			return nil, err
+		}
+		verifier = fixture
+	default:
+		return nil, fmt.Errorf("unknown oa.provider %q", provider)
+	}
+	return NewCachedVerifier(
+		verifier,
+		time.Duration(viper.GetInt("oa.cacheTTL"))*time.Hour,
+		time.Duration(viper.GetInt("oa.negativeCacheTTL"))*time.Minute,
+	), nil
+}
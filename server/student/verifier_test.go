@@ -0,0 +1,69 @@
+package student
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func setConfig(t *testing.T, values map[string]any) {
+	t.Helper()
+	for key, value := range values {
+		viper.Set(key, value)
+	}
+	t.Cleanup(viper.Reset)
+}
+
+func TestNewVerifierFixture(t *testing.T) {
+	setConfig(t, map[string]any{"env": "dev", "oa.provider": "fixture", "oa.fixture": "fixtures.json", "oa.cacheTTL": 1})
+	verifier, err := NewVerifier()
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+	if _, ok := verifier.(*CachedVerifier); !ok {
+		t.Errorf("NewVerifier() = %T, want the cache around the fixture", verifier)
+	}
+
+	record, err := verifier.Verify(context.Background(), testQuery)
+	if err != nil || record.Name != "Dev Student One" {
+		t.Fatalf("Verify() = %v, %v", record, err)
+	}
+	// The email is matched like OA does, the rest must be exact
+	upper := testQuery
+	upper.Email = "DEV1@iitk.ac.in"
+	if _, err := verifier.Verify(context.Background(), upper); err != nil {
+		t.Errorf("Verify() with the email in another case error = %v", err)
+	}
+	wrongDept := testQuery
+	wrongDept.Dept = "EE"
+	if _, err := verifier.Verify(context.Background(), wrongDept); !errors.Is(err, ErrNotVerified) {
+		t.Errorf("Verify() with a wrong department error = %v, want ErrNotVerified", err)
+	}
+}
+
+func TestNewVerifierFixtureRefusedInProd(t *testing.T) {
+	setConfig(t, map[string]any{"env": "prod", "oa.provider": "fixture", "oa.fixture": "fixtures.json"})
+	if _, err := NewVerifier(); err == nil {
+		t.Fatal("NewVerifier() accepted the fixture in prod")
+	}
+}
+
+func TestNewVerifierUnknownProvider(t *testing.T) {
+	setConfig(t, map[string]any{"oa.provider": "ldap"})
+	if _, err := NewVerifier(); err == nil {
+		t.Fatal("NewVerifier() accepted an unknown provider")
+	}
+}
+
+func TestNewVerifierDefaultsToHTTP(t *testing.T) {
+	setConfig(t, map[string]any{"oa.url": "http://localhost:1/verify"})
+	verifier, err := NewVerifier()
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+	if _, ok := verifier.(*CachedVerifier).next.(*OAVerifier); !ok {
+		t.Errorf("NewVerifier() wraps %T, want *OAVerifier", verifier.(*CachedVerifier).next)
+	}
+}
@@ -0,0 +1,74 @@
+// Helpers for the tests of the handlers, the server runs on sqlite with the config of the repo
+package testutil
+
+import (
+	"compass/connections"
+	"compass/model"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// sqliteUUID stands in for the gen_random_uuid() default of postgres, in the same text form uuid.UUID is stored in
+const sqliteUUID = "(lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-' || hex(randomblob(2)) || '-' || hex(randomblob(2)) || '-' || hex(randomblob(6))))"
+
+// UseDB points connections.DB to a fresh sqlite database with the tables of the user flows and the permissions
+func UseDB(t *testing.T) {
+	t.Helper()
+	dsn := "file:" + filepath.Join(t.TempDir(), "compass.db") + "?_pragma=busy_timeout(5000)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open test db: %v", err)
+	}
+	err = db.Callback().Raw().Before("gorm:raw").Register("test:uuid_default", func(tx *gorm.DB) {
+		if sql := tx.Statement.SQL.String(); strings.Contains(sql, "gen_random_uuid()") {
+			tx.Statement.SQL.Reset()
+			tx.Statement.SQL.WriteString(strings.ReplaceAll(sql, "gen_random_uuid()", sqliteUUID))
+		}
+	})
+	if err != nil {
+		t.Fatalf("register test db callback: %v", err)
+	}
+	if err := db.AutoMigrate(
+		&model.User{},
+		&model.Profile{},
+		&model.ChangeLog{},
+		&model.OneTimeCode{},
+		&model.Session{},
+		&model.RefreshToken{},
+		&model.Throttle{},
+		&model.TwoFactor{},
+		&model.RecoveryCode{},
+		&model.Passkey{},
+		&model.PermissionDef{},
+		&model.RolePermission{},
+		&model.APIKey{},
+		&model.Logs{},
+		&model.Impersonation{},
+	); err != nil {
+		t.Fatalf("migrate test db: %v", err)
+	}
+	previous := connections.DB
+	connections.DB = db
+	t.Cleanup(func() {
+		connections.DB = previous
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
+
+// SetConfig overrides config keys for the test
+func SetConfig(t *testing.T, values map[string]any) {
+	t.Helper()
+	for key, value := range values {
+		previous := viper.Get(key)
+		viper.Set(key, value)
+		t.Cleanup(func() { viper.Set(key, previous) })
+	}
+}
@@ -3,10 +3,13 @@ package workers
 import (
 	"compass/connections"
 	"compass/model"
+	"context"
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 func CleanupWorker() error {
@@ -15,14 +18,33 @@ func CleanupWorker() error {
 	defer ticker.Stop()
 
 	for range ticker.C {
-		if err := processUnverifiedUsers(); err != nil {
-			logrus.Errorf("Error processing unverified users: %v", err)
+		// An id per run, the mails queued by the run carry it as well
+		ctx := newRunContext()
+		log := logrus.WithContext(ctx)
+		if err := processUnverifiedUsers(ctx); err != nil {
+			log.Errorf("Error processing unverified users: %v", err)
+		}
+		if err := processExpiredSessions(); err != nil {
+			log.Errorf("Error processing expired sessions: %v", err)
+		}
+		if err := processStaleThrottles(); err != nil {
+			log.Errorf("Error processing stale throttles: %v", err)
+		}
+		if err := processAccountDeletions(ctx); err != nil {
+			log.Errorf("Error processing account deletions: %v", err)
+		}
+		if err := processExpiredExports(); err != nil {
+			log.Errorf("Error processing expired exports: %v", err)
+		}
+		if err := processExpiredImpersonations(ctx); err != nil {
+			log.Errorf("Error processing expired impersonations: %v", err)
 		}
 	}
 	return nil
 }
 
-func processUnverifiedUsers() error {
+func processUnverifiedUsers(ctx context.Context) error {
+	log := logrus.WithContext(ctx)
 	var users []model.User
 	// Find users created more than 24 hours ago and not verified
 	// We use Unscoped to find them even if they are already soft-deleted (though logic says they shouldn't be yet)
@@ -30,7 +52,8 @@ func processUnverifiedUsers() error {
 	// TODO: Set the delete time into the config, 6hrs is good enough.
 	threshold := time.Now().Add(-24 * time.Hour)
 
-	result := connections.DB.Preload("Profile").Where("is_verified = ? AND created_at < ?", false, threshold).Find(&users)
+	// updated_at, as resending the verification code gives the user another 24 hours
+	result := connections.DB.WithContext(ctx).Preload("Profile").Where("is_verified = ? AND updated_at < ?", false, threshold).Find(&users)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -39,7 +62,7 @@ func processUnverifiedUsers() error {
 		return nil
 	}
 
-	logrus.Infof("Found %d unverified users to cleanup", len(users))
+	log.Infof("Found %d unverified users to cleanup", len(users))
 
 	for _, user := range users {
 		// Email
@@ -57,24 +80,72 @@ func processUnverifiedUsers() error {
 
 		payload, err := json.Marshal(job)
 		if err != nil {
-			logrus.Errorf("Failed to marshal mail job for user %s: %v", user.UserID, err)
+			log.Errorf("Failed to marshal mail job for user %s: %v", user.UserID, err)
 			continue
 		}
 
-		if err := PublishJob(payload, "mail"); err != nil {
-			logrus.Errorf("Failed to publish mail job for user %s: %v", user.UserID, err)
+		if err := PublishJob(ctx, payload, "mail"); err != nil {
+			log.Errorf("Failed to publish mail job for user %s: %v", user.UserID, err)
 			// We might want to continue to delete even if email fails, or retry.
 			// For now, let's delete to ensure cleanup happens.
 		}
 		// TODO: test this worker + ensure the profile is also deleted if created.
 		// Delete User
 		// Unscoped().Delete() is used to perform a HARD DELETE.
-		if err := connections.DB.Unscoped().Delete(&user).Error; err != nil {
-			logrus.Errorf("Failed to delete user %s: %v", user.UserID, err)
+		if err := connections.DB.WithContext(ctx).Unscoped().Delete(&user).Error; err != nil {
+			log.Errorf("Failed to delete user %s: %v", user.UserID, err)
 		} else {
-			logrus.Infof("Deleted unverified user: %s", user.Email)
+			log.Infof("Deleted unverified user: %s", user.Email)
 		}
 	}
 
 	return nil
 }
+
+// Revoked tokens are kept till expiry for reuse detection, after that they are of no use
+func processExpiredSessions() error {
+	now := time.Now()
+	result := connections.DB.Where("expires_at < ?", now).Delete(&model.RefreshToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		logrus.Infof("Deleted %d expired refresh tokens", result.RowsAffected)
+	}
+	return connections.DB.Where("expires_at < ?", now).Delete(&model.Session{}).Error
+}
+
+// processExpiredImpersonations logs the end of the impersonations which were never stopped, the token
+// stopped working at ExpiresAt so that is when they ended
+func processExpiredImpersonations(ctx context.Context) error {
+	var expired []model.Impersonation
+	if err := connections.DB.WithContext(ctx).Where("ended_at IS NULL AND expires_at < ?", time.Now()).Find(&expired).Error; err != nil {
+		return err
+	}
+	for _, impersonation := range expired {
+		err := connections.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			// A stop may have come in since the lookup
+			result := tx.Model(&model.Impersonation{}).Where("id = ? AND ended_at IS NULL", impersonation.ID).
+				Update("ended_at", impersonation.ExpiresAt)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			entry := model.NewLog(
+				"Impersonation expired",
+				fmt.Sprintf("Impersonation %s of %s by admin %s expired at %s without a stop",
+					impersonation.ID, impersonation.UserID, impersonation.AdminID, impersonation.ExpiresAt.Format(time.RFC3339)),
+				model.BotActor,
+			)
+			return tx.Create(&entry).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Throttles untouched for a day have no failures left in their window nor an active block
+func processStaleThrottles() error {
+	return connections.DB.Where("updated_at < ?", time.Now().Add(-24*time.Hour)).Delete(&model.Throttle{}).Error
+}
@@ -0,0 +1,62 @@
+package workers
+
+import (
+	"compass/connections"
+	"compass/model"
+	"compass/testutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TestMain loads the config of the server without connecting to its services, the tests set up the DB they use
+func TestMain(m *testing.M) {
+	connections.LoadConfig("../")
+	os.Exit(m.Run())
+}
+
+func TestExpiredImpersonationsLogged(t *testing.T) {
+	testutil.UseDB(t)
+	now := time.Now()
+	stoppedAt := now.Add(-time.Hour)
+	expired := model.Impersonation{ID: uuid.New(), AdminID: uuid.New(), UserID: uuid.New(), ExpiresAt: now.Add(-time.Minute)}
+	stopped := model.Impersonation{ID: uuid.New(), AdminID: uuid.New(), UserID: uuid.New(), ExpiresAt: now.Add(-time.Minute), EndedAt: &stoppedAt}
+	active := model.Impersonation{ID: uuid.New(), AdminID: uuid.New(), UserID: uuid.New(), ExpiresAt: now.Add(time.Hour)}
+	for _, impersonation := range []*model.Impersonation{&expired, &stopped, &active} {
+		if err := connections.DB.Create(impersonation).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A second run must not log them again
+	for range 2 {
+		if err := processExpiredImpersonations(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var logs []model.Logs
+	if err := connections.DB.Find(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].Title != "Impersonation expired" || !strings.Contains(logs[0].Description, expired.ID.String()) {
+		t.Fatalf("got logs %+v, want one expiry of %s", logs, expired.ID)
+	}
+	var ended model.Impersonation
+	if err := connections.DB.First(&ended, "id = ?", expired.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if ended.EndedAt == nil || !ended.EndedAt.Equal(expired.ExpiresAt) {
+		t.Errorf("expired impersonation ended at %v, want its expiry %v", ended.EndedAt, expired.ExpiresAt)
+	}
+	var running model.Impersonation
+	if err := connections.DB.First(&running, "id = ?", active.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if running.EndedAt != nil {
+		t.Error("impersonation still running was ended")
+	}
+}
@@ -0,0 +1,152 @@
+package workers
+
+import (
+	"compass/connections"
+	"compass/model"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+func deletionGracePeriod() time.Duration {
+	return time.Duration(viper.GetInt("deletion.gracePeriod")) * 24 * time.Hour
+}
+
+// ScheduleAccountDeletion hides the profile right away and marks the account for the purge,
+// returns when the account will be purged. Asking again does not push the date.
+func ScheduleAccountDeletion(ctx context.Context, userID uuid.UUID) (time.Time, error) {
+	var user model.User
+	err := connections.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.User{}).Select("user_id", "email", "deletion_requested_at").
+			Where("user_id = ?", userID).First(&user).Error; err != nil {
+			return err
+		}
+		if user.DeletionRequestedAt == nil {
+			now := time.Now()
+			user.DeletionRequestedAt = &now
+			if err := tx.Model(&model.User{}).Where("user_id = ?", userID).
+				Update("deletion_requested_at", now).Error; err != nil {
+				return err
+			}
+		}
+		// Out of the search right away, the same as hiding the profile
+		if err := tx.Model(&model.Profile{}).Where("user_id = ?", userID).Update("visibility", false).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&model.ChangeLog{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.ChangeLog{UserID: userID, Action: model.Delete}).Error
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	purgeAt := user.DeletionRequestedAt.Add(deletionGracePeriod())
+	if err := sendEmail(ctx, MailJob{
+		Type: "account_deletion_scheduled",
+		To:   user.Email,
+		Data: map[string]interface{}{"purgeAt": purgeAt.Format("02 Jan 2006")},
+	}); err != nil {
+		logrus.WithContext(ctx).Errorf("Failed to queue deletion mail for user %s: %v", userID, err)
+	}
+	return purgeAt, nil
+}
+
+// CancelAccountDeletion drops a pending deletion request, called on every login.
+// The profile stays hidden, the user can turn the visibility back on.
+func CancelAccountDeletion(ctx context.Context, userID uuid.UUID) error {
+	var user model.User
+	result := connections.DB.WithContext(ctx).Model(&user).Select("user_id", "email").
+		Where("user_id = ? AND deletion_requested_at IS NOT NULL", userID).First(&user)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+	if err := connections.DB.WithContext(ctx).Model(&model.User{}).Where("user_id = ?", userID).
+		Update("deletion_requested_at", nil).Error; err != nil {
+		return err
+	}
+	log := logrus.WithContext(ctx)
+	log.Infof("Deletion of user %s cancelled by login", userID)
+	if err := sendEmail(ctx, MailJob{Type: "account_deletion_cancelled", To: user.Email}); err != nil {
+		log.Errorf("Failed to queue deletion cancelled mail for user %s: %v", userID, err)
+	}
+	return nil
+}
+
+// processAccountDeletions purges the accounts whose grace period is over
+func processAccountDeletions(ctx context.Context) error {
+	var users []model.User
+	if err := connections.DB.WithContext(ctx).Model(&model.User{}).Select("user_id", "email", "profile_pic").
+		Where("deletion_requested_at < ?", time.Now().Add(-deletionGracePeriod())).
+		Find(&users).Error; err != nil {
+		return err
+	}
+	log := logrus.WithContext(ctx)
+	for _, user := range users {
+		if err := purgeAccount(ctx, user); err != nil {
+			log.Errorf("Failed to purge user %s: %v", user.UserID, err)
+			continue
+		}
+		log.Infof("Purged user %s", user.UserID)
+		if err := sendEmail(ctx, MailJob{Type: "account_deleted", To: user.Email}); err != nil {
+			log.Errorf("Failed to queue account deleted mail for user %s: %v", user.UserID, err)
+		}
+	}
+	return nil
+}
+
+// purgeAccount hard deletes the user, its reviews stay without an author, its images and the pfp are removed.
+// Sessions, codes and the profile go with the user through the cascades.
+func purgeAccount(ctx context.Context, user model.User) error {
+	var images []model.Image
+	err := connections.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Anonymise, the rating is part of the location average and the text helps others
+		if err := tx.Model(&model.Review{}).Unscoped().Where("contributed_by = ?", user.UserID).
+			Update("contributed_by", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("owner_id = ?", user.UserID).Find(&images).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("owner_id = ?", user.UserID).Delete(&model.Image{}).Error; err != nil {
+			return err
+		}
+		entry := model.NewLog("Account purged", fmt.Sprintf("Account %s deleted on request after the grace period", user.UserID), model.BotActor)
+		if err := tx.Create(&entry).Error; err != nil {
+			return err
+		}
+		// The delete change log stays, search sync drops the profile with it
+		return tx.Unscoped().Where("user_id = ?", user.UserID).Delete(&model.User{}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	// Files last, a failed transaction must not leave rows pointing to missing files
+	for _, image := range images {
+		for _, dir := range []string{"./assets/public", "./assets/tmp"} {
+			removeFile(filepath.Join(dir, fmt.Sprintf("%s.webp", image.ImageID)))
+		}
+	}
+	if user.ProfilePic != "" {
+		removeFile(filepath.Join("./assets/pfp", filepath.Base(user.ProfilePic)))
+	}
+	return nil
+}
+
+func removeFile(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		logrus.Errorf("Failed to remove %s: %v", path, err)
+	}
+}
@@ -0,0 +1,226 @@
+package workers
+
+import (
+	"archive/zip"
+	"compass/connections"
+	"compass/model"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Archives are outside the static dirs, they are only served through the owner checked route
+const exportDir = "./assets/exports"
+
+func ExportWorker() error {
+	logrus.Info("Export worker is up and running...")
+	if err := os.MkdirAll(exportDir, 0o750); err != nil {
+		return err
+	}
+	msgs, err := connections.MQChannel.Consume(
+		viper.GetString("rabbitmq.exportqueue"), // queue
+		"",                                      // consumer tag
+		false,                                   // autoAck
+		false,                                   // exclusive
+		false,                                   // noLocal
+		false,                                   // noWait
+		nil,                                     // args
+	)
+	if err != nil {
+		return err
+	}
+	for task := range msgs {
+		tracked := trackJob(model.ExportQueue, task)
+		ctx := tracked.ctx
+		log := logrus.WithContext(ctx)
+		var job ExportJob
+		if err := json.Unmarshal(task.Body, &job); err != nil {
+			log.Errorf("Invalid export job format: %v", err)
+			tracked.nack(false) // don't requeue malformed messages
+			continue
+		}
+		if err := processExport(ctx, job.ExportID); err != nil {
+			log.Errorf("Export %s failed: %v", job.ExportID, err)
+			connections.DB.WithContext(ctx).Model(&model.DataExport{}).Where("export_id = ?", job.ExportID).
+				Update("status", model.ExportFailed)
+			tracked.nack(false)
+			continue
+		}
+		tracked.ack()
+	}
+	return fmt.Errorf("export worker channel closed unexpectedly")
+}
+
+// exportedUser is model.User without the secrets
+type exportedUser struct {
+	UserID              uuid.UUID  `json:"userId"`
+	Email               string     `json:"email"`
+	Role                model.Role `json:"role"`
+	IsVerified          bool       `json:"isVerified"`
+	ProfilePic          string     `json:"profilePic"`
+	CreatedAt           time.Time  `json:"createdAt"`
+	UpdatedAt           time.Time  `json:"updatedAt"`
+	DeletionRequestedAt *time.Time `json:"deletionRequestedAt,omitempty"`
+}
+
+// processExport builds the zip archive of everything we hold about the user and mails the link
+func processExport(ctx context.Context, exportID uuid.UUID) error {
+	var export model.DataExport
+	if err := connections.DB.WithContext(ctx).Where("export_id = ? AND status = ?", exportID, model.ExportPending).First(&export).Error; err != nil {
+		return err
+	}
+	var user model.User
+	if err := connections.DB.WithContext(ctx).Where("user_id = ?", export.UserID).First(&user).Error; err != nil {
+		return err
+	}
+
+	// Each entry of the archive, queried separately so a large table does not need a join
+	var (
+		profile   []model.Profile
+		locations []model.Location
+		notices   []model.Notice
+		reviews   []model.Review
+		images    []model.Image
+		changes   []model.ChangeLog
+		sessions  []model.Session
+		passkeys  []model.Passkey
+	)
+	db := connections.DB.WithContext(ctx)
+	queries := []error{
+		db.Where("user_id = ?", user.UserID).Find(&profile).Error,
+		db.Where("contributed_by = ?", user.UserID).Find(&locations).Error,
+		db.Where("contributed_by = ?", user.UserID).Find(&notices).Error,
+		db.Where("contributed_by = ?", user.UserID).Find(&reviews).Error,
+		db.Where("owner_id = ?", user.UserID).Find(&images).Error,
+		db.Where("user_id = ?", user.UserID).Find(&changes).Error,
+		db.Where("user_id = ?", user.UserID).Find(&sessions).Error,
+		db.Where("user_id = ?", user.UserID).Find(&passkeys).Error,
+	}
+	for _, err := range queries {
+		if err != nil {
+			return err
+		}
+	}
+
+	path := filepath.Join(exportDir, exportID.String()+".zip")
+	if err := writeExportArchive(path, map[string]interface{}{
+		"user.json": exportedUser{
+			UserID:              user.UserID,
+			Email:               user.Email,
+			Role:                user.Role,
+			IsVerified:          user.IsVerified,
+			ProfilePic:          user.ProfilePic,
+			CreatedAt:           user.CreatedAt,
+			UpdatedAt:           user.UpdatedAt,
+			DeletionRequestedAt: user.DeletionRequestedAt,
+		},
+		"profile.json":   profile,
+		"locations.json": locations,
+		"notices.json":   notices,
+		"reviews.json":   reviews,
+		"images.json":    images,
+		"changelog.json": changes,
+		"sessions.json":  sessions,
+		"passkeys.json":  passkeys,
+	}, images, user.ProfilePic); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(viper.GetInt("export.expiry")) * time.Hour)
+	if err := connections.DB.WithContext(ctx).Model(&export).Updates(map[string]interface{}{
+		"status":       model.ExportReady,
+		"file_path":    path,
+		"completed_at": now,
+		"expires_at":   expiresAt,
+	}).Error; err != nil {
+		os.Remove(path)
+		return err
+	}
+	logrus.WithContext(ctx).Infof("Export %s ready for user %s", exportID, user.UserID)
+	return sendEmail(ctx, MailJob{
+		Type: "data_export_ready",
+		To:   user.Email,
+		Data: map[string]interface{}{
+			"link":   fmt.Sprintf("%s/%s", viper.GetString("export.downloadUrl"), exportID),
+			"expiry": viper.GetInt("export.expiry"),
+		},
+	})
+}
+
+func writeExportArchive(path string, documents map[string]interface{}, images []model.Image, profilePic string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	archive := zip.NewWriter(file)
+
+	for name, document := range documents {
+		w, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(document); err != nil {
+			return err
+		}
+	}
+	// Images which are still in tmp (not yet moderated) are theirs as well
+	for _, image := range images {
+		name := fmt.Sprintf("%s.webp", image.ImageID)
+		for _, dir := range []string{"./assets/public", "./assets/tmp"} {
+			if err := addFileToArchive(archive, filepath.Join(dir, name), "images/"+name); err == nil {
+				break
+			}
+		}
+	}
+	if profilePic != "" {
+		name := filepath.Base(profilePic)
+		if err := addFileToArchive(archive, filepath.Join("./assets/pfp", name), "pfp/"+name); err != nil {
+			logrus.Warnf("Profile picture %s missing from the export: %v", name, err)
+		}
+	}
+	return archive.Close()
+}
+
+func addFileToArchive(archive *zip.Writer, src string, name string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// processExpiredExports removes the archives once their link has expired
+func processExpiredExports() error {
+	var exports []model.DataExport
+	if err := connections.DB.Where("expires_at < ?", time.Now()).Find(&exports).Error; err != nil {
+		return err
+	}
+	for _, export := range exports {
+		if export.FilePath != "" {
+			removeFile(export.FilePath)
+		}
+		if err := connections.DB.Delete(&export).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
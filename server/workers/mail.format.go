@@ -33,6 +33,22 @@ func FormatMail(job MailJob) (MailContent, error) {
 		return formatGenericNotice(job)
 	case "account_deletion":
 		return formatAccountDeletionEmail(job)
+	case "account_deletion_scheduled":
+		return formatDeletionScheduledEmail(job)
+	case "account_deletion_cancelled":
+		return formatDeletionCancelledEmail(job)
+	case "account_deleted":
+		return formatAccountDeletedEmail(job)
+	case "data_export_ready":
+		return formatDataExportEmail(job)
+	case "email_change":
+		return formatEmailChangeEmail(job)
+	case "email_changed":
+		return formatEmailChangedEmail(job)
+	case "magic_link":
+		return formatMagicLinkEmail(job)
+	case "password_reset":
+		return formatPasswordResetEmail(job)
 	default:
 		return MailContent{}, fmt.Errorf("unknown mail type: %s", job.Type)
 	}
@@ -188,6 +204,184 @@ func formatAccountDeletionEmail(job MailJob) (MailContent, error) {
 	}, nil
 }
 
+func formatPasswordResetEmail(job MailJob) (MailContent, error) {
+	data := map[string]interface{}{
+		"Token":  job.Data["token"],
+		"Link":   job.Data["link"],
+		"Expiry": job.Data["expiry"],
+	}
+	tmpl := `
+		<h2>Password reset requested</h2>
+		<p>Below is your otp to reset the password of your account:</p>
+		<h2>{{.Token}}</h2>
+		<p>Enter it on the <a href="{{.Link}}">reset page</a> along with your new password.</p>
+		<p>This otp is valid for next {{.Expiry}} minutes</p>
+		<p>If this action was not taken by you, please ignore this mail, your password will remain unchanged. Do not share this otp with anyone</p>
+	`
+	body, err := renderTemplate(tmpl, data)
+	if err != nil {
+		return MailContent{}, err
+	}
+	return MailContent{
+		To:      job.To,
+		Subject: "Reset Your Password",
+		Body:    body,
+		IsHTML:  true,
+	}, nil
+}
+
+func formatDeletionScheduledEmail(job MailJob) (MailContent, error) {
+	data := map[string]interface{}{
+		"PurgeAt": job.Data["purgeAt"],
+	}
+	tmpl := `
+		<h2>Your account is scheduled for deletion</h2>
+		<p>As requested, your profile has been hidden and your account will be permanently deleted on {{.PurgeAt}}.</p>
+		<p>Your reviews will stay without your name, your images and profile picture will be removed.</p>
+		<p>Changed your mind? Just login before that date and the deletion will be cancelled.</p>
+	`
+	body, err := renderTemplate(tmpl, data)
+	if err != nil {
+		return MailContent{}, err
+	}
+	return MailContent{
+		To:      job.To,
+		Subject: "Account Deletion Scheduled",
+		Body:    body,
+		IsHTML:  true,
+	}, nil
+}
+
+func formatDeletionCancelledEmail(job MailJob) (MailContent, error) {
+	tmpl := `
+		<h2>Account deletion cancelled</h2>
+		<p>You logged in to your account, so the pending deletion has been cancelled.</p>
+		<p>Your profile is still hidden from the search, you can make it visible again from your profile.</p>
+		<p>If this login was not you, please reset your password.</p>
+	`
+	body, err := renderTemplate(tmpl, nil)
+	if err != nil {
+		return MailContent{}, err
+	}
+	return MailContent{
+		To:      job.To,
+		Subject: "Account Deletion Cancelled",
+		Body:    body,
+		IsHTML:  true,
+	}, nil
+}
+
+func formatAccountDeletedEmail(job MailJob) (MailContent, error) {
+	tmpl := `
+		<h2>Your account has been deleted</h2>
+		<p>As requested, your account and personal data have been permanently deleted from Campus Compass.</p>
+		<p>Thank you for being a part of it, you are welcome to sign up again anytime.</p>
+	`
+	body, err := renderTemplate(tmpl, nil)
+	if err != nil {
+		return MailContent{}, err
+	}
+	return MailContent{
+		To:      job.To,
+		Subject: "Account Deleted",
+		Body:    body,
+		IsHTML:  true,
+	}, nil
+}
+
+func formatDataExportEmail(job MailJob) (MailContent, error) {
+	data := map[string]interface{}{
+		"Link":   job.Data["link"],
+		"Expiry": job.Data["expiry"],
+	}
+	tmpl := `
+		<h2>Your data is ready</h2>
+		<p>The archive with everything Campus Compass holds about you is ready to <a href="{{.Link}}">download</a>.</p>
+		<p>You need to be logged in to download it, the link is valid for next {{.Expiry}} hours.</p>
+		<p>If you did not request this export, please reset your password.</p>
+	`
+	body, err := renderTemplate(tmpl, data)
+	if err != nil {
+		return MailContent{}, err
+	}
+	return MailContent{
+		To:      job.To,
+		Subject: "Your Data Export",
+		Body:    body,
+		IsHTML:  true,
+	}, nil
+}
+
+func formatEmailChangeEmail(job MailJob) (MailContent, error) {
+	data := map[string]interface{}{
+		"Token":  job.Data["token"],
+		"Expiry": job.Data["expiry"],
+	}
+	tmpl := `
+		<h2>Confirm your new email</h2>
+		<p>Below is your otp to use this address for your Campus Compass account:</p>
+		<h2>{{.Token}}</h2>
+		<p>This otp is valid for next {{.Expiry}} minutes</p>
+		<p>If this action was not taken by you, please ignore this mail. Do not share this otp with anyone</p>
+	`
+	body, err := renderTemplate(tmpl, data)
+	if err != nil {
+		return MailContent{}, err
+	}
+	return MailContent{
+		To:      job.To,
+		Subject: "Confirm Your New Email",
+		Body:    body,
+		IsHTML:  true,
+	}, nil
+}
+
+func formatEmailChangedEmail(job MailJob) (MailContent, error) {
+	data := map[string]interface{}{
+		"NewEmail": job.Data["newEmail"],
+	}
+	tmpl := `
+		<h2>Your email has been changed</h2>
+		<p>The email of your Campus Compass account has been changed to {{.NewEmail}}, this address can no longer be used to login.</p>
+		<p>All other devices have been logged out.</p>
+		<p>If this was not you, please contact us immediately by replying to this mail.</p>
+	`
+	body, err := renderTemplate(tmpl, data)
+	if err != nil {
+		return MailContent{}, err
+	}
+	return MailContent{
+		To:      job.To,
+		Subject: "Your Email Was Changed",
+		Body:    body,
+		IsHTML:  true,
+	}, nil
+}
+
+func formatMagicLinkEmail(job MailJob) (MailContent, error) {
+	data := map[string]interface{}{
+		"Link":   job.Data["link"],
+		"Expiry": job.Data["expiry"],
+	}
+	tmpl := `
+		<h2>Login to Campus Compass</h2>
+		<p>Click the link below to login, open it in the same browser you requested it from:</p>
+		<p><a href="{{.Link}}">Login to Campus Compass</a></p>
+		<p>This link is valid for next {{.Expiry}} minutes and works only once</p>
+		<p>If this action was not taken by you, please ignore this mail. Do not forward this link to anyone</p>
+	`
+	body, err := renderTemplate(tmpl, data)
+	if err != nil {
+		return MailContent{}, err
+	}
+	return MailContent{
+		To:      job.To,
+		Subject: "Your Login Link",
+		Body:    body,
+		IsHTML:  true,
+	}, nil
+}
+
 // ========== Template Helper ==========
 
 func renderTemplate(tmpl string, data interface{}) (string, error) {
@@ -5,6 +5,7 @@ package workers
 
 import (
 	"compass/connections"
+	"compass/model"
 	"encoding/json"
 	"fmt"
 
@@ -30,28 +31,34 @@ func MailingWorker() error {
 	}
 	// Process messages in a goroutine
 	for delivery := range msgs {
+		tracked := trackJob(model.MailQueue, delivery)
+		// Logged with the request id of whoever queued the mail
+		log := logrus.WithContext(tracked.ctx)
 		var job MailJob
 		// Try to decode the message body into a MailJob struct
 		if err := json.Unmarshal(delivery.Body, &job); err != nil {
-			logrus.Errorf("Failed to unmarshal mail job: %v", err)
-			delivery.Nack(false, false) // don't requeue malformed messages
+			log.Errorf("Failed to unmarshal mail job: %v", err)
+			tracked.nack(false) // don't requeue malformed messages
 			continue
 		}
 		// Format the email content
 		content, err := FormatMail(job)
 		if err != nil {
-			logrus.Errorf("Failed to format mail: %v", err)
-			delivery.Nack(false, true) // Retry formatting errors
+			log.Errorf("Failed to format mail: %v", err)
+			mailsFailed.WithLabelValues(job.Type, "format").Inc()
+			tracked.nack(true) // Retry formatting errors
 			continue
 		}
 		// Send the email
 		if err := SendMail(content); err != nil {
-			logrus.Errorf("Failed to send email to %s: %v", content.To, err)
-			delivery.Nack(false, true) // Retry send errors
+			log.Errorf("Failed to send email to %s: %v", content.To, err)
+			mailsFailed.WithLabelValues(job.Type, "send").Inc()
+			tracked.nack(true) // Retry send errors
 			continue
 		}
-		logrus.Infof("Successfully sent email to %s [%s]", content.To, job.Type)
-		delivery.Ack(false)
+		log.Infof("Successfully sent email to %s [%s]", content.To, job.Type)
+		mailsSent.WithLabelValues(job.Type).Inc()
+		tracked.ack()
 	}
 	return fmt.Errorf("mail queue channel closed unexpectedly")
 }
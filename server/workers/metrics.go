@@ -0,0 +1,106 @@
+package workers
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	jobsPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "compass",
+		Subsystem: "jobs",
+		Name:      "published_total",
+		Help:      "Jobs published by queue.",
+	}, []string{"queue"})
+	jobsPublishFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "compass",
+		Subsystem: "jobs",
+		Name:      "publish_failures_total",
+		Help:      "Jobs that could not be published by queue.",
+	}, []string{"queue"})
+	jobsAcked = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "compass",
+		Subsystem: "jobs",
+		Name:      "acked_total",
+		Help:      "Jobs processed by queue.",
+	}, []string{"queue"})
+	jobsNacked = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "compass",
+		Subsystem: "jobs",
+		Name:      "nacked_total",
+		Help:      "Jobs rejected by queue, requeue tells if they go back to the queue.",
+	}, []string{"queue", "requeue"})
+	jobsInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "compass",
+		Subsystem: "jobs",
+		Name:      "in_flight",
+		Help:      "Jobs received and not yet acked or nacked by queue.",
+	}, []string{"queue"})
+
+	moderationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "compass",
+		Subsystem: "moderation",
+		Name:      "duration_seconds",
+		Help:      "Time taken by the OpenAI moderation by asset type.",
+		Buckets:   []float64{0.25, 0.5, 1, 2, 4, 8, 16, 32},
+	}, []string{"type"})
+	moderationResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "compass",
+		Subsystem: "moderation",
+		Name:      "results_total",
+		Help:      "Moderation outcomes by asset type, result is approved, flagged or error.",
+	}, []string{"type", "result"})
+
+	mailsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "compass",
+		Subsystem: "mail",
+		Name:      "sent_total",
+		Help:      "Mails sent by type.",
+	}, []string{"type"})
+	mailsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "compass",
+		Subsystem: "mail",
+		Name:      "failed_total",
+		Help:      "Mails that failed by type and stage (format or send).",
+	}, []string{"type", "stage"})
+)
+
+// trackedJob is a delivery being processed, counted and traced from its receipt till the ack or nack
+type trackedJob struct {
+	queue    string
+	delivery amqp.Delivery
+	ctx      context.Context // carries the request id and the span of the job
+	span     trace.Span
+}
+
+func trackJob(queue string, delivery amqp.Delivery) trackedJob {
+	jobsInFlight.WithLabelValues(queue).Inc()
+	ctx, span := jobContext(delivery, queue)
+	return trackedJob{queue: queue, delivery: delivery, ctx: ctx, span: span}
+}
+
+func (t trackedJob) ack() {
+	t.delivery.Ack(false)
+	jobsInFlight.WithLabelValues(t.queue).Dec()
+	jobsAcked.WithLabelValues(t.queue).Inc()
+	t.span.End()
+}
+
+func (t trackedJob) nack(requeue bool) {
+	t.delivery.Nack(false, requeue)
+	jobsInFlight.WithLabelValues(t.queue).Dec()
+	label := "false"
+	if requeue {
+		label = "true"
+	}
+	jobsNacked.WithLabelValues(t.queue, label).Inc()
+	t.span.SetAttributes(attribute.Bool("messaging.requeue", requeue))
+	t.span.SetStatus(codes.Error, "job nacked")
+	t.span.End()
+}
@@ -17,6 +17,10 @@ type MailContent struct {
 	IsHTML  bool
 }
 
+type ExportJob struct {
+	ExportID uuid.UUID `json:"export_id"`
+}
+
 type ModerationJob struct {
 	AssetID uuid.UUID `json:"asset_id"`
 	Type    string    `json:"type"`
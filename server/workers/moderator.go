@@ -6,8 +6,11 @@ package workers
 import (
 	"compass/connections"
 	"compass/model"
+	"context"
 	"encoding/json"
 	"fmt"
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -30,75 +33,93 @@ func ModeratorWorker() error {
 	}
 	// Continuously consume over the messages
 	for task := range msgs {
+		tracked := trackJob(model.ModerationQueue, task)
+		ctx := tracked.ctx
+		log := logrus.WithContext(ctx)
 		var job ModerationJob
 		// Try to decode the message body into a ModerationJob struct
 		if err := json.Unmarshal(task.Body, &job); err != nil {
-			logrus.Errorf("Invalid moderation job format: %v", err)
-			task.Nack(false, false) // don't requeue malformed messages
+			log.Errorf("Invalid moderation job format: %v", err)
+			tracked.nack(false) // don't requeue malformed messages
 			continue
 		}
 
-		flagged, err := moderateJob(job)
+		start := time.Now()
+		flagged, err := moderateJob(ctx, job)
+		moderationDuration.WithLabelValues(job.Type).Observe(time.Since(start).Seconds())
+		moderationResults.WithLabelValues(job.Type, moderationResult(flagged, err)).Inc()
 		if err != nil {
-			logrus.Errorf("Moderation error for\nID: %s\nType: %s\nError: %v", job.AssetID, job.Type, err)
+			log.Errorf("Moderation error for\nID: %s\nType: %s\nError: %v", job.AssetID, job.Type, err)
 			// TODO: Drop the messages if they are tried multiple times
-			task.Nack(false, false) // don't requeue, improve on this logic later
+			tracked.nack(false) // don't requeue, improve on this logic later
 			// task.Nack(false, true)
 			continue
 		}
 
 		// Fetch image and owner
-		image, user, err := getImageAndUser(job.AssetID)
+		image, user, err := getImageAndUser(ctx, job.AssetID)
 		if err != nil {
-			logrus.Errorf("Failed to get image or user for\nID: %s\nError: %v", job.AssetID, err)
-			task.Nack(false, false)
+			log.Errorf("Failed to get image or user for\nID: %s\nError: %v", job.AssetID, err)
+			tracked.nack(false)
 			continue
 		}
 
 		if flagged {
-			if err := handleFlaggedImage(image, user); err != nil {
-				logrus.Errorf("Failed to handle flagged image for\nID: %s\nError: %v", job.AssetID, err)
-				task.Nack(false, false)
+			if err := handleFlaggedImage(ctx, image, user); err != nil {
+				log.Errorf("Failed to handle flagged image for\nID: %s\nError: %v", job.AssetID, err)
+				tracked.nack(false)
 				continue
 			}
 		} else {
-			if err := handleApprovedImage(job.AssetID, image, user); err != nil {
-				logrus.Errorf("Failed to handle approved image for\nID: %s\nError: %v", job.AssetID, err)
-				task.Nack(false, false)
+			if err := handleApprovedImage(ctx, job.AssetID, image, user); err != nil {
+				log.Errorf("Failed to handle approved image for\nID: %s\nError: %v", job.AssetID, err)
+				tracked.nack(false)
 				continue
 			}
 		}
 		// Remove the task form queue, confirm that it is processed
-		task.Ack(false)
+		tracked.ack()
 	}
 
 	return fmt.Errorf("moderation worker channel closed unexpectedly")
 }
 
+// moderationResult is the result label of the moderation metrics
+func moderationResult(flagged bool, err error) string {
+	switch {
+	case err != nil:
+		return "error"
+	case flagged:
+		return "flagged"
+	default:
+		return "approved"
+	}
+}
+
 // moderateJob decides flagged/approved status based on type
-func moderateJob(job ModerationJob) (bool, error) {
+func moderateJob(ctx context.Context, job ModerationJob) (bool, error) {
 	// Switch according to type
 	switch job.Type {
 	case model.ModerationTypeReviewText:
-		return ModerateText(job.AssetID)
+		return ModerateText(ctx, job.AssetID)
 	case model.ModerationTypeImage:
-		return ModerateImage(job.AssetID)
+		return ModerateImage(ctx, job.AssetID)
 	default:
-		logrus.Infof("Unknown moderation job type: %s", job.Type)
+		logrus.WithContext(ctx).Infof("Unknown moderation job type: %s", job.Type)
 		return false, nil
 	}
 }
 
 // getImageAndUser fetches image and its owner from DB
-func getImageAndUser(assetID uuid.UUID) (model.Image, model.User, error) {
+func getImageAndUser(ctx context.Context, assetID uuid.UUID) (model.Image, model.User, error) {
 	imageID := assetID.String()
 	var image model.Image
-	if err := connections.DB.First(&image, "image_id = ?", imageID).Error; err != nil {
+	if err := connections.DB.WithContext(ctx).First(&image, "image_id = ?", imageID).Error; err != nil {
 		return image, model.User{}, err
 	}
 
 	var user model.User
-	if err := connections.DB.First(&user, "user_id = ?", image.OwnerID).Error; err != nil {
+	if err := connections.DB.WithContext(ctx).First(&user, "user_id = ?", image.OwnerID).Error; err != nil {
 		return image, user, err
 	}
 
@@ -106,7 +127,7 @@ func getImageAndUser(assetID uuid.UUID) (model.Image, model.User, error) {
 }
 
 // handleFlaggedImage sends violation email and updates DB
-func handleFlaggedImage(image model.Image, user model.User) error {
+func handleFlaggedImage(ctx context.Context, image model.Image, user model.User) error {
 	imageID := image.ImageID.String()
 
 	mailJob := MailJob{
@@ -117,11 +138,11 @@ func handleFlaggedImage(image model.Image, user model.User) error {
 			"reason":   "Your uploaded image violated our content policy and was rejected.",
 		},
 	}
-	if err := sendEmail(mailJob); err != nil {
-		logrus.Errorf("Failed to queue violation email for %s: %v", user.Email, err)
+	if err := sendEmail(ctx, mailJob); err != nil {
+		logrus.WithContext(ctx).Errorf("Failed to queue violation email for %s: %v", user.Email, err)
 	}
 
-	if err := connections.DB.Model(&model.Image{}).
+	if err := connections.DB.WithContext(ctx).Model(&model.Image{}).
 		Where("image_id = ?", imageID).
 		Update("status", model.Rejected).Error; err != nil {
 		return err
@@ -131,18 +152,19 @@ func handleFlaggedImage(image model.Image, user model.User) error {
 }
 
 // handleApprovedImage moves image, updates DB, and sends thank-you email
-func handleApprovedImage(assetID uuid.UUID, image model.Image, user model.User) error {
+func handleApprovedImage(ctx context.Context, assetID uuid.UUID, image model.Image, user model.User) error {
 	imageID := assetID.String()
+	log := logrus.WithContext(ctx)
 
 	// This is a critical error, so return error, and mark the task unfinished.
 	if err := MoveImageFromTmpToPublic(assetID); err != nil {
-		logrus.Errorf("Failed to move image %s to public: %v", imageID, err)
+		log.Errorf("Failed to move image %s to public: %v", imageID, err)
 		return err
 	} else {
-		logrus.Infof("Image with ID: %s successfully moved from tmp to public", imageID)
+		log.Infof("Image with ID: %s successfully moved from tmp to public", imageID)
 	}
 
-	if err := connections.DB.Model(&model.Image{}).
+	if err := connections.DB.WithContext(ctx).Model(&model.Image{}).
 		Where("image_id = ?", imageID).
 		Update("status", model.Approved).Error; err != nil {
 		return err
@@ -156,15 +178,15 @@ func handleApprovedImage(assetID uuid.UUID, image model.Image, user model.User)
 			"content_title": "Your uploaded image",
 		},
 	}
-	if err := sendEmail(mailJob); err != nil {
-		logrus.Errorf("Failed to queue thank-you email for %s: %v", user.Email, err)
+	if err := sendEmail(ctx, mailJob); err != nil {
+		log.Errorf("Failed to queue thank-you email for %s: %v", user.Email, err)
 	}
 
 	return nil
 }
 
 // this method marshals the job and publishes to mail queue
-func sendEmail(mailJob MailJob) error {
+func sendEmail(ctx context.Context, mailJob MailJob) error {
 	payload, _ := json.Marshal(mailJob)
-	return PublishJob(payload, "mail")
+	return PublishJob(ctx, payload, "mail")
 }
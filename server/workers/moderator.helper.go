@@ -30,7 +30,7 @@ func loadImageAsBase64(imageID uuid.UUID) (string, error) {
 }
 
 // ModerateImage Call OpenAI Moderation API
-func ModerateImage(imageID uuid.UUID) (bool, error) {
+func ModerateImage(ctx context.Context, imageID uuid.UUID) (bool, error) {
 	// Read Image
 	base64Image, err := loadImageAsBase64(imageID)
 	if err != nil {
@@ -54,27 +54,27 @@ func ModerateImage(imageID uuid.UUID) (bool, error) {
 			},
 		},
 	}
-	moderationRes, err := connections.AI.Moderations.New(context.TODO(), params)
+	moderationRes, err := connections.AI.Moderations.New(ctx, params)
 	if err != nil {
-		logrus.Error("Failed in open AI request")
+		logrus.WithContext(ctx).Error("Failed in open AI request")
 		return false, err
 	}
 	return moderationRes.Results[0].Flagged, nil
 }
 
-func ModerateText(reviewID uuid.UUID) (bool, error) {
+func ModerateText(ctx context.Context, reviewID uuid.UUID) (bool, error) {
 	var review model.Review
-	if err := connections.DB.Find(&model.Review{}).Where("review_id = ?", reviewID).First(&review).Error; err != nil {
-		logrus.Error("Error fetching review for moderation")
+	if err := connections.DB.WithContext(ctx).Find(&model.Review{}).Where("review_id = ?", reviewID).First(&review).Error; err != nil {
+		logrus.WithContext(ctx).Error("Error fetching review for moderation")
 		return false, err
 	}
 	// var result moderationResponse =
-	moderationRes, err := connections.AI.Moderations.New(context.TODO(), openai.ModerationNewParams{
+	moderationRes, err := connections.AI.Moderations.New(ctx, openai.ModerationNewParams{
 		Model: openai.ModerationModelOmniModeration2024_09_26,
 		Input: openai.ModerationNewParamsInputUnion{OfString: param.NewOpt(review.Description)},
 	})
 	if err != nil {
-		logrus.Error("Failed in open AI request")
+		logrus.WithContext(ctx).Error("Failed in open AI request")
 		return false, err
 	}
 	return moderationRes.Results[0].Flagged, nil
@@ -2,17 +2,95 @@ package workers
 
 import (
 	"compass/connections"
+	"context"
 	"fmt"
 
+	"github.com/google/uuid"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func PublishJob(payload []byte, queueName string) error {
+// Header carrying the id of the request that published the job
+const requestIDHeader = "X-Request-ID"
+
+// PublishJob queues the payload, the request id and the trace in ctx go along so the worker can be matched with the request
+func PublishJob(ctx context.Context, payload []byte, queueName string) error {
 	queue := viper.GetString(fmt.Sprintf("rabbitmq.%squeue", queueName))
-	return connections.MQChannel.Publish("", queue, false, false,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        payload,
-		})
+	ctx, span := connections.Tracer.Start(ctx, queueName+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(messagingAttributes(queue, "publish")...),
+	)
+	defer span.End()
+	msg := amqp.Publishing{
+		ContentType: "application/json",
+		Body:        payload,
+		Headers:     amqp.Table{},
+	}
+	if id := connections.RequestID(ctx); id != "" {
+		msg.Headers[requestIDHeader] = id
+		msg.CorrelationId = id
+	}
+	otel.GetTextMapPropagator().Inject(ctx, amqpHeaders(msg.Headers))
+	if err := connections.MQChannel.PublishWithContext(ctx, "", queue, false, false, msg); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "publish failed")
+		jobsPublishFailed.WithLabelValues(queueName).Inc()
+		return err
+	}
+	jobsPublished.WithLabelValues(queueName).Inc()
+	return nil
+}
+
+// jobContext gives the context to process a delivery with, tagged with the request id of the publisher
+// and inside a consumer span continuing its trace. Jobs without an id (like those queued before the upgrade) get a fresh one.
+// The span is ended by the caller once the job is acked or nacked.
+func jobContext(delivery amqp.Delivery, queueName string) (context.Context, trace.Span) {
+	id, _ := delivery.Headers[requestIDHeader].(string)
+	if id == "" {
+		id = uuid.NewString()
+	}
+	ctx := connections.WithRequestID(context.Background(), id)
+	ctx = otel.GetTextMapPropagator().Extract(ctx, amqpHeaders(delivery.Headers))
+	return connections.Tracer.Start(ctx, queueName+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(messagingAttributes(delivery.RoutingKey, "process")...),
+	)
+}
+
+// newRunContext tags a run of a background task (like the cleanup) with its own id
+func newRunContext() context.Context {
+	return connections.WithRequestID(context.Background(), uuid.NewString())
+}
+
+func messagingAttributes(queue string, operation string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.MessagingSystemRabbitMQ,
+		semconv.MessagingDestinationName(queue),
+		semconv.MessagingOperationName(operation),
+	}
+}
+
+// amqpHeaders lets the propagator read and write the trace context in the message headers
+type amqpHeaders amqp.Table
+
+func (h amqpHeaders) Get(key string) string {
+	value, _ := h[key].(string)
+	return value
+}
+
+func (h amqpHeaders) Set(key string, value string) {
+	h[key] = value
+}
+
+func (h amqpHeaders) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	return keys
 }